@@ -0,0 +1,147 @@
+package socket
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+type AdmissionDecision byte
+
+const (
+	AdmissionAccept AdmissionDecision = iota
+	// AdmissionRejectQuiet closes the connection without writing anything.
+	AdmissionRejectQuiet
+	// AdmissionRejectWithPacket writes the packet produced by the configured RejectPacketProducer before closing. It behaves as AdmissionRejectQuiet when no producer is configured.
+	AdmissionRejectWithPacket
+)
+
+// Admission is evaluated for every accepted connection before the Creator runs.
+type Admission func(ip net.IP) AdmissionDecision
+
+func defaultAdmission(_ net.IP) AdmissionDecision {
+	return AdmissionAccept
+}
+
+// RejectPacketProducer returns the raw bytes written to a rejected connection, typically a handshake followed by a region specific ban notice.
+type RejectPacketProducer func(ip net.IP) []byte
+
+// AccessList is an Admission source combining CIDR allow and deny lists with a ban list. All methods are safe for concurrent use, so bans may be issued while the server is running.
+type AccessList struct {
+	mu    sync.RWMutex
+	allow []*net.IPNet
+	deny  []*net.IPNet
+	bans  map[string]time.Time
+	now   func() time.Time
+}
+
+//goland:noinspection GoUnusedExportedFunction
+func NewAccessList() *AccessList {
+	return &AccessList{bans: make(map[string]time.Time), now: time.Now}
+}
+
+// Allow restricts admission to addresses within the CIDR. Once any allow entry exists, addresses outside every allow entry are rejected quietly.
+func (a *AccessList) Allow(cidr string) error {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.allow = append(a.allow, n)
+	return nil
+}
+
+// Deny rejects addresses within the CIDR quietly.
+func (a *AccessList) Deny(cidr string) error {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.deny = append(a.deny, n)
+	return nil
+}
+
+// Ban rejects an address with the ban packet until the duration elapses. A zero duration bans permanently.
+func (a *AccessList) Ban(ip net.IP, duration time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var expiry time.Time
+	if duration > 0 {
+		expiry = a.now().Add(duration)
+	}
+	a.bans[ip.String()] = expiry
+}
+
+func (a *AccessList) Unban(ip net.IP) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.bans, ip.String())
+}
+
+// Banned reports whether an address is banned, removing a ban which has expired.
+func (a *AccessList) Banned(ip net.IP) bool {
+	key := ip.String()
+	a.mu.RLock()
+	expiry, ok := a.bans[key]
+	a.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	now := a.now()
+	if expiry.IsZero() || now.Before(expiry) {
+		return true
+	}
+
+	// The address may have been banned again since the expired ban was read, so only that ban is removed.
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	current, ok := a.bans[key]
+	if !ok {
+		return false
+	}
+	if current.Equal(expiry) {
+		delete(a.bans, key)
+		return false
+	}
+	return current.IsZero() || now.Before(current)
+}
+
+func (a *AccessList) Evaluate(ip net.IP) AdmissionDecision {
+	if a.Banned(ip) {
+		return AdmissionRejectWithPacket
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, n := range a.deny {
+		if n.Contains(ip) {
+			return AdmissionRejectQuiet
+		}
+	}
+	if len(a.allow) == 0 {
+		return AdmissionAccept
+	}
+	for _, n := range a.allow {
+		if n.Contains(ip) {
+			return AdmissionAccept
+		}
+	}
+	return AdmissionRejectQuiet
+}
+
+func (a *AccessList) Admission() Admission {
+	return a.Evaluate
+}
+
+func ipOf(addr net.Addr) net.IP {
+	switch v := addr.(type) {
+	case *net.TCPAddr:
+		return v.IP
+	case *net.UDPAddr:
+		return v.IP
+	}
+	return net.ParseIP(hostOf(addr))
+}
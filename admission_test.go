@@ -0,0 +1,90 @@
+package socket
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAccessList(t *testing.T) {
+	a := NewAccessList()
+	if err := a.Allow("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Deny("10.1.0.0/16"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Deny("not-a-cidr"); err == nil {
+		t.Fatalf("Expected invalid CIDR to error.")
+	}
+
+	cases := []struct {
+		ip       string
+		expected AdmissionDecision
+	}{
+		{"10.0.0.1", AdmissionAccept},
+		{"10.1.2.3", AdmissionRejectQuiet},
+		{"192.168.0.1", AdmissionRejectQuiet},
+	}
+	for _, c := range cases {
+		if d := a.Evaluate(net.ParseIP(c.ip)); d != c.expected {
+			t.Errorf("%s: expected %d got %d.", c.ip, c.expected, d)
+		}
+	}
+}
+
+func TestAccessListBanExpiry(t *testing.T) {
+	a := NewAccessList()
+	now := time.Unix(1000, 0)
+	a.now = func() time.Time {
+		return now
+	}
+
+	ip := net.ParseIP("203.0.113.7")
+	a.Ban(ip, time.Minute)
+	if d := a.Evaluate(ip); d != AdmissionRejectWithPacket {
+		t.Fatalf("Expected banned address to be rejected with packet, got %d.", d)
+	}
+
+	now = now.Add(time.Minute)
+	if d := a.Evaluate(ip); d != AdmissionAccept {
+		t.Fatalf("Expected ban to expire, got %d.", d)
+	}
+
+	a.Ban(ip, 0)
+	now = now.Add(24 * time.Hour)
+	if !a.Banned(ip) {
+		t.Fatalf("Expected permanent ban.")
+	}
+	a.Unban(ip)
+	if a.Banned(ip) {
+		t.Fatalf("Expected unban to lift the ban.")
+	}
+}
+
+func TestAccessListKeepsBanIssuedDuringExpiry(t *testing.T) {
+	a := NewAccessList()
+	ip := net.ParseIP("203.0.113.7")
+	now := time.Unix(1000, 0)
+	a.now = func() time.Time {
+		return now
+	}
+	a.Ban(ip, time.Minute)
+	now = now.Add(time.Minute)
+
+	// The ban is renewed between Banned reading the expired ban and removing it.
+	renewed := false
+	a.now = func() time.Time {
+		if !renewed {
+			renewed = true
+			a.Ban(ip, time.Hour)
+		}
+		return now
+	}
+	if !a.Banned(ip) {
+		t.Errorf("Expected the renewed ban to apply.")
+	}
+	if !a.Banned(ip) {
+		t.Errorf("Expected the renewed ban to be kept.")
+	}
+}
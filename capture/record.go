@@ -0,0 +1,94 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"io"
+	"sync"
+	"time"
+)
+
+type Direction string
+
+const (
+	Inbound  Direction = "in"
+	Outbound Direction = "out"
+)
+
+// Bytes renders as hex in JSON.
+type Bytes []byte
+
+func (b Bytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(b)), nil
+}
+
+func (b *Bytes) UnmarshalText(text []byte) error {
+	d, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*b = d
+	return nil
+}
+
+// Record is a decrypted packet. Data holds the full packet, op included.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Direction Direction `json:"dir"`
+	SessionId uuid.UUID `json:"session"`
+	Op        uint16    `json:"op"`
+	Data      Bytes     `json:"data"`
+}
+
+// Recorder receives captured packets. Implementations must be safe for concurrent use.
+type Recorder interface {
+	Record(r Record)
+}
+
+// JSONLWriter writes one record per line.
+type JSONLWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+//goland:noinspection GoUnusedExportedFunction
+func NewJSONLWriter(w io.Writer) *JSONLWriter {
+	return &JSONLWriter{enc: json.NewEncoder(w)}
+}
+
+func (j *JSONLWriter) Record(r Record) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err != nil {
+		return
+	}
+	j.err = j.enc.Encode(r)
+}
+
+// Err is the first write error, after which further records are discarded.
+func (j *JSONLWriter) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// ReadJSONL reads every record written by a JSONLWriter.
+func ReadJSONL(r io.Reader) ([]Record, error) {
+	var result []Record
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var rec Record
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		result = append(result, rec)
+	}
+}
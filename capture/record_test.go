@@ -0,0 +1,32 @@
+package capture
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONLRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewJSONLWriter(buf)
+	id := uuid.New()
+	now := time.Unix(1700000000, 0).UTC()
+	w.Record(Record{Time: now, Direction: Inbound, SessionId: id, Op: 0x01, Data: Bytes{0x01, 0x00, 0xFF}})
+	w.Record(Record{Time: now.Add(time.Second), Direction: Outbound, SessionId: id, Op: 0x02, Data: Bytes{0x02, 0x00}})
+	if w.Err() != nil {
+		t.Fatal(w.Err())
+	}
+	if !strings.Contains(buf.String(), `"data":"0100ff"`) {
+		t.Errorf("Expected hex encoded data, got %s.", buf.String())
+	}
+
+	records, err := ReadJSONL(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].SessionId != id || records[1].Direction != Outbound || !bytes.Equal(records[0].Data, []byte{0x01, 0x00, 0xFF}) || !records[1].Time.Equal(now.Add(time.Second)) {
+		t.Errorf("Unexpected records %+v.", records)
+	}
+}
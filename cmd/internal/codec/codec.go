@@ -0,0 +1,46 @@
+// Package codec resolves the cipher scheme and op encoding flags shared by the command line tools.
+package codec
+
+import (
+	"fmt"
+	"github.com/Chronicle20/atlas-socket"
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"github.com/Chronicle20/atlas-socket/request"
+	"strings"
+)
+
+var schemes = map[string]crypto.Scheme{
+	"aesofb":    crypto.SchemeAESOFB,
+	"shanda":    crypto.SchemeShanda,
+	"plaintext": crypto.SchemePlaintext,
+}
+
+var opReaders = map[string]socket.OpReader{
+	"short": socket.ShortReadWriter{},
+	"byte":  socket.ByteReadWriter{},
+}
+
+// Scheme resolves a -scheme flag value, one of aesofb, shanda or plaintext.
+func Scheme(name string) (crypto.Scheme, error) {
+	s, ok := schemes[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown scheme [%s]", name)
+	}
+	return s, nil
+}
+
+// OpReader resolves a -op flag value, short for the two byte ops of most versions or byte for the single byte ops of early clients.
+func OpReader(name string) (socket.OpReader, error) {
+	r, ok := opReaders[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown op encoding [%s]", name)
+	}
+	return r, nil
+}
+
+// Op reads the op at the start of a decrypted packet. Packets too short to hold one yield zero.
+func Op(r socket.OpReader, packet []byte) uint16 {
+	p := request.Request(packet)
+	reader := request.NewRequestReader(&p, 0)
+	return r.Read(&reader)
+}
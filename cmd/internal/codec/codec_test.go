@@ -0,0 +1,40 @@
+package codec
+
+import (
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"testing"
+)
+
+func TestOp(t *testing.T) {
+	short, err := OpReader("short")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byteOps, err := OpReader("BYTE")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet := []byte{0x12, 0x34, 0x56}
+	if op := Op(short, packet); op != 0x3412 {
+		t.Errorf("Short op read as 0x%04X.", op)
+	}
+	if op := Op(byteOps, packet); op != 0x0012 {
+		t.Errorf("Byte op read as 0x%04X.", op)
+	}
+	if op := Op(short, []byte{0x12}); op != 0 {
+		t.Errorf("Truncated op read as 0x%04X.", op)
+	}
+	if _, err = OpReader("int"); err == nil {
+		t.Errorf("Expected an unknown op encoding to be rejected.")
+	}
+}
+
+func TestScheme(t *testing.T) {
+	if s, err := Scheme("Shanda"); err != nil || s != crypto.SchemeShanda {
+		t.Errorf("Unexpected scheme [%v], error [%v].", s, err)
+	}
+	if _, err := Scheme("rc4"); err == nil {
+		t.Errorf("Expected an unknown scheme to be rejected.")
+	}
+}
@@ -0,0 +1,72 @@
+// Package opcode loads the opcode name tables shared by the command line tools.
+package opcode
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Chronicle20/atlas-socket/capture"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Table names opcodes per direction. Inbound is client to server.
+type Table struct {
+	Inbound  map[uint16]string
+	Outbound map[uint16]string
+}
+
+type file struct {
+	Inbound  map[string]string `json:"inbound"`
+	Outbound map[string]string `json:"outbound"`
+}
+
+// Read reads a JSON document of the form {"inbound": {"0x0001": "LOGIN_PASSWORD"}, "outbound": {...}}. Opcodes may be decimal or 0x prefixed hex.
+func Read(r io.Reader) (Table, error) {
+	var f file
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return Table{}, err
+	}
+	in, err := parseOpcodes(f.Inbound)
+	if err != nil {
+		return Table{}, err
+	}
+	out, err := parseOpcodes(f.Outbound)
+	if err != nil {
+		return Table{}, err
+	}
+	return Table{Inbound: in, Outbound: out}, nil
+}
+
+func parseOpcodes(names map[string]string) (map[uint16]string, error) {
+	result := make(map[uint16]string, len(names))
+	for k, v := range names {
+		op, err := strconv.ParseUint(k, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid opcode [%s]: %w", k, err)
+		}
+		result[uint16(op)] = v
+	}
+	return result, nil
+}
+
+// Name returns the opcode name for a direction, or an empty string.
+func (t Table) Name(dir capture.Direction, op uint16) string {
+	if dir == capture.Inbound {
+		return t.Inbound[op]
+	}
+	return t.Outbound[op]
+}
+
+// Load reads the table at path. An empty path yields an empty table.
+func Load(path string) (Table, error) {
+	if path == "" {
+		return Table{}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return Table{}, err
+	}
+	defer f.Close()
+	return Read(f)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/Chronicle20/atlas-socket"
+	"github.com/Chronicle20/atlas-socket/capture"
+	"github.com/Chronicle20/atlas-socket/cmd/internal/codec"
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"github.com/google/uuid"
+	"time"
+)
+
+// maxPacketBytes guards against runaway lengths when a stream is misaligned, for example after capture loss.
+const maxPacketBytes = 1 << 20
+
+var sessionNamespace = uuid.MustParse("5c2f6f0e-8a43-4d4b-9a4e-3f1f0a9c2b11")
+
+// Packet is a decrypted packet recovered from a capture.
+type Packet struct {
+	Time      time.Time
+	SessionId uuid.UUID
+	Client    endpoint
+	Server    endpoint
+	Direction capture.Direction
+	Version   uint16
+	Op        uint16
+	Data      []byte
+}
+
+type direction struct {
+	buf    []byte
+	cipher crypto.Cipher
+	dir    capture.Direction
+	dead   bool
+}
+
+// conversation tracks one TCP connection from the handshake onwards.
+type conversation struct {
+	id        uuid.UUID
+	a         endpoint
+	b         endpoint
+	server    *endpoint
+	handshake crypto.Handshake
+	dirs      map[endpoint]*direction
+	streams   map[endpoint]*stream
+}
+
+// Decoder turns TCP segments into decrypted packets.
+type Decoder struct {
+	profile       crypto.CryptoProfile
+	ops           socket.OpReader
+	conversations map[string]*conversation
+	emit          func(Packet)
+	warn          func(format string, args ...interface{})
+}
+
+func NewDecoder(profile crypto.CryptoProfile, ops socket.OpReader, emit func(Packet), warn func(format string, args ...interface{})) *Decoder {
+	return &Decoder{
+		profile:       profile,
+		ops:           ops,
+		conversations: make(map[string]*conversation),
+		emit:          emit,
+		warn:          warn,
+	}
+}
+
+func conversationKey(a endpoint, b endpoint) string {
+	if a.String() > b.String() {
+		a, b = b, a
+	}
+	return a.String() + "|" + b.String()
+}
+
+func (d *Decoder) add(seg segment) {
+	key := conversationKey(seg.src, seg.dst)
+	c, ok := d.conversations[key]
+	if !ok || (seg.syn && c.server != nil) {
+		c = &conversation{
+			id:      uuid.NewSHA1(sessionNamespace, []byte(key+seg.ts.String())),
+			a:       seg.src,
+			b:       seg.dst,
+			dirs:    make(map[endpoint]*direction),
+			streams: make(map[endpoint]*stream),
+		}
+		d.conversations[key] = c
+	}
+
+	s, ok := c.streams[seg.src]
+	if !ok {
+		src := seg.src
+		s = newStream(func(ts time.Time, data []byte) {
+			d.deliver(c, src, ts, data)
+		})
+		c.streams[seg.src] = s
+	}
+	s.add(seg)
+}
+
+func (d *Decoder) deliver(c *conversation, src endpoint, ts time.Time, data []byte) {
+	dir, ok := c.dirs[src]
+	if !ok {
+		dir = &direction{}
+		c.dirs[src] = dir
+	}
+	if dir.dead {
+		return
+	}
+	dir.buf = append(dir.buf, data...)
+
+	if c.server == nil {
+		h, err := crypto.ReadHandshake(bytes.NewReader(dir.buf))
+		if err != nil {
+			if len(dir.buf) >= 2 && int(binary.LittleEndian.Uint16(dir.buf))+2 <= len(dir.buf) {
+				// A complete frame which is not a hello, the capture began mid-connection.
+				d.warn("No handshake found for [%s <-> %s], ignoring connection.", c.a, c.b)
+				for _, other := range c.dirs {
+					other.dead = true
+					other.buf = nil
+				}
+				dir.dead = true
+				dir.buf = nil
+			}
+			return
+		}
+		if err = d.start(c, src, h); err != nil {
+			d.warn("Unable to create ciphers for [%s <-> %s]: %s", c.a, c.b, err)
+			for _, other := range c.dirs {
+				other.dead = true
+				other.buf = nil
+			}
+			dir.dead = true
+			return
+		}
+		dir.buf = dir.buf[len(h.Bytes()):]
+		for ep, other := range c.dirs {
+			if ep != src {
+				d.drain(c, other, ts)
+			}
+		}
+	}
+	d.drain(c, dir, ts)
+}
+
+// start keys both directions from the handshake sent by server.
+func (d *Decoder) start(c *conversation, server endpoint, h crypto.Handshake) error {
+	p := d.profile
+	p.Version = h.Version
+	toServer, err := p.NewCipher(h.RecvIv, h.Version)
+	if err != nil {
+		return err
+	}
+	toClient, err := p.NewCipher(h.SendIv, 0xFFFF-h.Version)
+	if err != nil {
+		return err
+	}
+
+	c.server = &server
+	c.handshake = h
+	client := c.a
+	if client == server {
+		client = c.b
+	}
+	for _, ep := range []endpoint{server, client} {
+		if _, ok := c.dirs[ep]; !ok {
+			c.dirs[ep] = &direction{}
+		}
+	}
+	c.dirs[server].cipher, c.dirs[server].dir = toClient, capture.Outbound
+	c.dirs[client].cipher, c.dirs[client].dir = toServer, capture.Inbound
+	return nil
+}
+
+func (d *Decoder) drain(c *conversation, dir *direction, ts time.Time) {
+	for !dir.dead && len(dir.buf) >= 4 {
+		length := dir.cipher.PacketLength(dir.buf[:4])
+		if length < 0 || length > maxPacketBytes {
+			d.warn("Invalid packet length [%d] in [%s <-> %s], abandoning direction.", length, c.a, c.b)
+			dir.dead = true
+			dir.buf = nil
+			return
+		}
+		if len(dir.buf) < 4+length {
+			return
+		}
+		body := make([]byte, length)
+		copy(body, dir.buf[4:4+length])
+		dir.buf = dir.buf[4+length:]
+		crypto.DecryptPacket(dir.cipher, body)
+
+		client, server := c.a, *c.server
+		if client == server {
+			client = c.b
+		}
+		d.emit(Packet{Time: ts, SessionId: c.id, Client: client, Server: server, Direction: dir.dir, Version: c.handshake.Version, Op: codec.Op(d.ops, body), Data: body})
+	}
+	if len(dir.buf) == 0 {
+		dir.buf = nil
+	}
+}
@@ -0,0 +1,166 @@
+// Command mapledump decrypts MapleStory traffic from pcap and pcapng captures.
+//
+// TCP streams are reassembled, the unencrypted hello sent by the server is used to recover the version and both IVs, and every subsequent packet is decrypted and printed, or exported as capture JSONL which can be fed to socket.Replay.
+//
+//	mapledump -ops opcodes.json -port 8484 login.pcapng
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Chronicle20/atlas-socket/capture"
+	"github.com/Chronicle20/atlas-socket/cmd/internal/codec"
+	"github.com/Chronicle20/atlas-socket/cmd/internal/opcode"
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"io"
+	"os"
+	"time"
+)
+
+func main() {
+	opsPath := flag.String("ops", "", "JSON opcode table used to name packets")
+	format := flag.String("format", "text", "output format, text or jsonl")
+	outPath := flag.String("o", "", "output file, stdout when empty")
+	region := flag.String("region", string(crypto.RegionGMS), "region used to look up the user key")
+	scheme := flag.String("scheme", "aesofb", "cipher scheme, one of aesofb, shanda or plaintext")
+	op := flag.String("op", "short", "op encoding, short or byte")
+	port := flag.Uint("port", 0, "only decode connections using this TCP port")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] capture.pcap...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(options{
+		opsPath: *opsPath,
+		format:  *format,
+		outPath: *outPath,
+		region:  crypto.Region(*region),
+		scheme:  *scheme,
+		op:      *op,
+		port:    uint16(*port),
+		inputs:  flag.Args(),
+	}); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "mapledump: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+type options struct {
+	opsPath string
+	format  string
+	outPath string
+	region  crypto.Region
+	scheme  string
+	op      string
+	port    uint16
+	inputs  []string
+}
+
+func run(o options) error {
+	s, err := codec.Scheme(o.scheme)
+	if err != nil {
+		return err
+	}
+	ops, err := codec.OpReader(o.op)
+	if err != nil {
+		return err
+	}
+
+	table, err := opcode.Load(o.opsPath)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if o.outPath != "" {
+		f, err := os.Create(o.outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	bw := bufio.NewWriter(out)
+	defer bw.Flush()
+
+	emit, err := printer(bw, o.format, table)
+	if err != nil {
+		return err
+	}
+	warn := func(format string, args ...interface{}) {
+		_, _ = fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+	d := NewDecoder(crypto.CryptoProfile{Region: o.region, Scheme: s}, ops, emit, warn)
+
+	for _, path := range o.inputs {
+		if err = decodeFile(d, path, o.port); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func decodeFile(d *Decoder, path string, port uint16) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return decodeCapture(d, f, port)
+}
+
+func decodeCapture(d *Decoder, r io.Reader, port uint16) error {
+	fr, err := openCapture(r)
+	if err != nil {
+		return err
+	}
+	for {
+		f, err := fr.next()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// Captures cut short by a stopped capture still decode up to the last whole frame.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		seg, ok := decodeFrame(f)
+		if !ok {
+			continue
+		}
+		if port != 0 && seg.src.port != port && seg.dst.port != port {
+			continue
+		}
+		d.add(seg)
+	}
+}
+
+func printer(w io.Writer, format string, table opcode.Table) (func(Packet), error) {
+	switch format {
+	case "text":
+		return func(p Packet) {
+			arrow := fmt.Sprintf("%s -> %s", p.Client, p.Server)
+			if p.Direction == capture.Outbound {
+				arrow = fmt.Sprintf("%s <- %s", p.Client, p.Server)
+			}
+			name := table.Name(p.Direction, p.Op)
+			if name == "" {
+				name = "UNKNOWN"
+			}
+			_, _ = fmt.Fprintf(w, "%s %s %-3s 0x%04X %s len=%d\n%s", p.Time.Format(time.RFC3339Nano), arrow, p.Direction, p.Op, name, len(p.Data), hex.Dump(p.Data))
+		}, nil
+	case "jsonl":
+		jw := capture.NewJSONLWriter(w)
+		return func(p Packet) {
+			jw.Record(capture.Record{Time: p.Time, Direction: p.Direction, SessionId: p.SessionId, Op: p.Op, Data: p.Data})
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown format [%s]", format)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/Chronicle20/atlas-socket"
+	"github.com/Chronicle20/atlas-socket/capture"
+	"github.com/Chronicle20/atlas-socket/cmd/internal/opcode"
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type captured struct {
+	ts   time.Time
+	data []byte
+}
+
+// tcpFrame builds an Ethernet, IPv4 and TCP frame.
+func tcpFrame(src net.IP, srcPort uint16, dst net.IP, dstPort uint16, seq uint32, flags byte, payload []byte) []byte {
+	tcp := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	tcp = append(tcp, payload...)
+
+	ip := make([]byte, 20, 20+len(tcp))
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+	ip[8] = 64
+	ip[9] = 6
+	copy(ip[12:], src.To4())
+	copy(ip[16:], dst.To4())
+	ip = append(ip, tcp...)
+
+	eth := make([]byte, 14, 14+len(ip))
+	binary.BigEndian.PutUint16(eth[12:], 0x0800)
+	return append(eth, ip...)
+}
+
+func writePcap(frames []captured) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, 0xA1B2C3D4)
+	b = binary.LittleEndian.AppendUint16(b, 2)
+	b = binary.LittleEndian.AppendUint16(b, 4)
+	b = append(b, make([]byte, 8)...)
+	b = binary.LittleEndian.AppendUint32(b, 65535)
+	b = binary.LittleEndian.AppendUint32(b, linkTypeEthernet)
+	for _, f := range frames {
+		b = binary.LittleEndian.AppendUint32(b, uint32(f.ts.Unix()))
+		b = binary.LittleEndian.AppendUint32(b, uint32(f.ts.Nanosecond()/1000))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(f.data)))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(f.data)))
+		b = append(b, f.data...)
+	}
+	return b
+}
+
+func pcapngBlock(b []byte, blockType uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	total := uint32(12 + len(body))
+	b = binary.BigEndian.AppendUint32(b, blockType)
+	b = binary.BigEndian.AppendUint32(b, total)
+	b = append(b, body...)
+	return binary.BigEndian.AppendUint32(b, total)
+}
+
+// writePcapng writes a big endian pcapng with nanosecond timestamps to exercise both.
+func writePcapng(frames []captured) []byte {
+	shb := binary.BigEndian.AppendUint32(nil, pcapngByteOrderMagic)
+	shb = binary.BigEndian.AppendUint16(shb, 1)
+	shb = binary.BigEndian.AppendUint16(shb, 0)
+	shb = binary.BigEndian.AppendUint64(shb, 0xFFFFFFFFFFFFFFFF)
+	b := pcapngBlock(nil, pcapngSectionHeader, shb)
+
+	idb := binary.BigEndian.AppendUint16(nil, linkTypeEthernet)
+	idb = binary.BigEndian.AppendUint16(idb, 0)
+	idb = binary.BigEndian.AppendUint32(idb, 65535)
+	idb = binary.BigEndian.AppendUint16(idb, pcapngOptionTsResol)
+	idb = binary.BigEndian.AppendUint16(idb, 1)
+	idb = append(idb, 9, 0, 0, 0)
+	idb = append(idb, 0, 0, 0, 0)
+	b = pcapngBlock(b, pcapngInterface, idb)
+
+	for _, f := range frames {
+		ts := uint64(f.ts.UnixNano())
+		epb := binary.BigEndian.AppendUint32(nil, 0)
+		epb = binary.BigEndian.AppendUint32(epb, uint32(ts>>32))
+		epb = binary.BigEndian.AppendUint32(epb, uint32(ts))
+		epb = binary.BigEndian.AppendUint32(epb, uint32(len(f.data)))
+		epb = binary.BigEndian.AppendUint32(epb, uint32(len(f.data)))
+		epb = append(epb, f.data...)
+		b = pcapngBlock(b, pcapngEnhancedPacket, epb)
+	}
+	return b
+}
+
+type conversationBuilder struct {
+	client  net.IP
+	server  net.IP
+	cport   uint16
+	sport   uint16
+	cseq    uint32
+	sseq    uint32
+	now     time.Time
+	frames  []captured
+	toSrv   *crypto.SendCipher
+	toCli   *crypto.SendCipher
+	encrypt func(*crypto.SendCipher, []byte) []byte
+}
+
+func (b *conversationBuilder) add(fromClient bool, payload []byte) {
+	b.now = b.now.Add(time.Millisecond)
+	if fromClient {
+		b.frames = append(b.frames, captured{b.now, tcpFrame(b.client, b.cport, b.server, b.sport, b.cseq, 0x18, payload)})
+		b.cseq += uint32(len(payload))
+		return
+	}
+	b.frames = append(b.frames, captured{b.now, tcpFrame(b.server, b.sport, b.client, b.cport, b.sseq, 0x18, payload)})
+	b.sseq += uint32(len(payload))
+}
+
+func packet(op uint16, body ...byte) []byte {
+	return append(binary.LittleEndian.AppendUint16(nil, op), body...)
+}
+
+func buildConversation() (*conversationBuilder, [][]byte) {
+	h := crypto.Handshake{Version: 83, Patch: "1", RecvIv: []byte{0x01, 0x02, 0x03, 0x04}, SendIv: []byte{0x05, 0x06, 0x07, 0x08}, Locale: 8}
+	b := &conversationBuilder{
+		client: net.IPv4(10, 0, 0, 2), server: net.IPv4(10, 0, 0, 1),
+		cport: 51000, sport: 8484, cseq: 1000, sseq: 5000,
+		now:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		toSrv: crypto.NewSendCipher(h.RecvIv, h.Version),
+		toCli: crypto.NewSendCipher(h.SendIv, 0xFFFF-h.Version),
+	}
+	enc := func(s *crypto.SendCipher, p []byte) []byte {
+		return s.Encrypt(true, true)(append(make([]byte, 4), p...))
+	}
+
+	b.frames = append(b.frames, captured{b.now, tcpFrame(b.client, b.cport, b.server, b.sport, b.cseq-1, 0x02, nil)})
+	b.frames = append(b.frames, captured{b.now, tcpFrame(b.server, b.sport, b.client, b.cport, b.sseq-1, 0x12, nil)})
+	b.add(false, h.Bytes())
+
+	login := packet(0x0001, []byte("admin")...)
+	status := packet(0x0000, 0x00, 0x01)
+	big := packet(0x0002, bytes.Repeat([]byte{0xAB}, 3000)...)
+	pong := packet(0x0018)
+
+	e := enc(b.toSrv, login)
+	// The login packet arrives in two segments.
+	b.add(true, e[:3])
+	b.add(true, e[3:])
+	b.add(false, enc(b.toCli, status))
+
+	// A large packet whose second segment is captured before its first.
+	e = enc(b.toSrv, big)
+	first, second := e[:1400], e[1400:]
+	b.now = b.now.Add(time.Millisecond)
+	b.frames = append(b.frames, captured{b.now, tcpFrame(b.client, b.cport, b.server, b.sport, b.cseq+1400, 0x18, second)})
+	b.add(true, first)
+	b.cseq += uint32(len(second))
+	// A retransmission of the first segment is ignored.
+	b.frames = append(b.frames, captured{b.now, tcpFrame(b.client, b.cport, b.server, b.sport, b.cseq-uint32(len(e)), 0x18, first)})
+
+	// Two packets coalesced into one segment.
+	b.add(true, append(enc(b.toSrv, pong), enc(b.toSrv, pong)...))
+	return b, [][]byte{login, status, big, pong, pong}
+}
+
+func decodeAll(t *testing.T, data []byte) []Packet {
+	var result []Packet
+	d := NewDecoder(crypto.CryptoProfile{Region: crypto.RegionGMS}, socket.ShortReadWriter{}, func(p Packet) {
+		result = append(result, p)
+	}, func(format string, args ...interface{}) {
+		t.Logf(format, args...)
+	})
+	if err := decodeCapture(d, bytes.NewReader(data), 8484); err != nil {
+		t.Fatalf("Unable to decode capture: %s", err)
+	}
+	return result
+}
+
+func TestDecodeCapture(t *testing.T) {
+	b, expected := buildConversation()
+	directions := []capture.Direction{capture.Inbound, capture.Outbound, capture.Inbound, capture.Inbound, capture.Inbound}
+
+	for name, data := range map[string][]byte{"pcap": writePcap(b.frames), "pcapng": writePcapng(b.frames)} {
+		t.Run(name, func(t *testing.T) {
+			packets := decodeAll(t, data)
+			if len(packets) != len(expected) {
+				t.Fatalf("Decoded [%d] packets, expected [%d].", len(packets), len(expected))
+			}
+			for i, p := range packets {
+				if !bytes.Equal(p.Data, expected[i]) {
+					t.Errorf("Packet [%d] decoded to [% X], expected [% X].", i, p.Data, expected[i])
+				}
+				if p.Direction != directions[i] {
+					t.Errorf("Packet [%d] direction [%s], expected [%s].", i, p.Direction, directions[i])
+				}
+				if p.Version != 83 || p.Server.port != 8484 || p.Client.port != 51000 {
+					t.Errorf("Packet [%d] has unexpected connection details [%+v].", i, p)
+				}
+			}
+			if !packets[0].Time.Equal(time.Date(2024, 1, 2, 3, 4, 5, 3_000_000, time.UTC)) {
+				t.Errorf("Login packet time [%s] should be that of its final segment.", packets[0].Time)
+			}
+		})
+	}
+}
+
+func TestPrinterNamesOpcodes(t *testing.T) {
+	table, err := opcode.Read(strings.NewReader(`{"inbound": {"0x0001": "LOGIN_PASSWORD"}, "outbound": {"0": "LOGIN_STATUS"}}`))
+	if err != nil {
+		t.Fatalf("Unable to read opcode table: %s", err)
+	}
+	b, _ := buildConversation()
+
+	out := &bytes.Buffer{}
+	emit, err := printer(out, "text", table)
+	if err != nil {
+		t.Fatalf("Unable to create printer: %s", err)
+	}
+	d := NewDecoder(crypto.CryptoProfile{Region: crypto.RegionGMS}, socket.ShortReadWriter{}, emit, t.Logf)
+	if err = decodeCapture(d, bytes.NewReader(writePcap(b.frames)), 0); err != nil {
+		t.Fatalf("Unable to decode capture: %s", err)
+	}
+	for _, s := range []string{"10.0.0.2:51000 -> 10.0.0.1:8484 in  0x0001 LOGIN_PASSWORD", "10.0.0.2:51000 <- 10.0.0.1:8484 out 0x0000 LOGIN_STATUS", "0x0018 UNKNOWN"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("Output missing [%s]:\n%s", s, out.String())
+		}
+	}
+}
+
+func TestDecodeJSONLReplayable(t *testing.T) {
+	b, expected := buildConversation()
+	out := &bytes.Buffer{}
+	emit, _ := printer(out, "jsonl", opcode.Table{})
+	d := NewDecoder(crypto.CryptoProfile{Region: crypto.RegionGMS}, socket.ShortReadWriter{}, emit, t.Logf)
+	if err := decodeCapture(d, bytes.NewReader(writePcap(b.frames)), 0); err != nil {
+		t.Fatalf("Unable to decode capture: %s", err)
+	}
+	records, err := capture.ReadJSONL(out)
+	if err != nil {
+		t.Fatalf("Unable to read records: %s", err)
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("Read [%d] records, expected [%d].", len(records), len(expected))
+	}
+	if records[0].Op != 0x0001 || records[0].SessionId != records[4].SessionId {
+		t.Errorf("Unexpected records [%+v].", records)
+	}
+}
+
+func TestMidstreamConnectionIgnored(t *testing.T) {
+	b, _ := buildConversation()
+	// Drop the handshake so the capture starts after it.
+	frames := append([]captured{}, b.frames[:2]...)
+	frames = append(frames, b.frames[3:]...)
+	if packets := decodeAll(t, writePcap(frames)); len(packets) != 0 {
+		t.Errorf("Decoded [%d] packets without a handshake.", len(packets))
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := openCapture(bytes.NewReader(make([]byte, 64))); err != ErrUnknownFormat {
+		t.Errorf("Expected [%s], got [%v].", ErrUnknownFormat, err)
+	}
+}
+
+func TestStreamRetransmissionFillsGap(t *testing.T) {
+	var out []byte
+	s := newStream(func(_ time.Time, data []byte) {
+		out = append(out, data...)
+	})
+	seg := func(seq uint32, payload string) segment {
+		return segment{seq: seq, payload: []byte(payload)}
+	}
+
+	s.add(segment{seq: 99, syn: true})
+	s.add(seg(100, "abcd"))
+	// "ijkl" arrives before the lost "efgh", then a retransmission covers the gap and overlaps both neighbours.
+	s.add(seg(108, "ijkl"))
+	s.add(seg(102, "cdefghij"))
+	s.add(seg(112, "mn"))
+	s.add(seg(100, "ab"))
+
+	if string(out) != "abcdefghijklmn" {
+		t.Errorf("Reassembled [%s].", out)
+	}
+	if len(s.pending) != 0 {
+		t.Errorf("Expected no pending segments, got [%d].", len(s.pending))
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"time"
+)
+
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeRawAlt   = 12
+	linkTypeLoop     = 108
+	linkTypeSll      = 113
+	linkTypeSll2     = 276
+
+	pcapngSectionHeader   = 0x0A0D0D0A
+	pcapngInterface       = 0x00000001
+	pcapngSimplePacket    = 0x00000003
+	pcapngEnhancedPacket  = 0x00000006
+	pcapngByteOrderMagic  = 0x1A2B3C4D
+	pcapngOptionTsResol   = 9
+	maxCaptureRecordBytes = 1 << 24
+)
+
+var ErrUnknownFormat = errors.New("not a pcap or pcapng file")
+
+// frame is a captured link layer frame.
+type frame struct {
+	ts       time.Time
+	linkType uint16
+	data     []byte
+}
+
+type frameReader interface {
+	next() (frame, error)
+}
+
+// openCapture detects pcap or pcapng from the leading magic.
+func openCapture(r io.Reader) (frameReader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(magic) == pcapngSectionHeader {
+		return &pcapngReader{r: br}, nil
+	}
+	return newPcapReader(br)
+}
+
+type pcapReader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nanos    bool
+	linkType uint16
+}
+
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	p := &pcapReader{r: r}
+	switch {
+	case binary.LittleEndian.Uint32(header) == 0xA1B2C3D4:
+		p.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(header) == 0xA1B2C3D4:
+		p.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(header) == 0xA1B23C4D:
+		p.order, p.nanos = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(header) == 0xA1B23C4D:
+		p.order, p.nanos = binary.BigEndian, true
+	default:
+		return nil, ErrUnknownFormat
+	}
+	p.linkType = uint16(p.order.Uint32(header[20:]))
+	return p, nil
+}
+
+func (p *pcapReader) next() (frame, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(p.r, header); err != nil {
+		return frame{}, err
+	}
+	sec := int64(p.order.Uint32(header[0:]))
+	frac := int64(p.order.Uint32(header[4:]))
+	size := p.order.Uint32(header[8:])
+	if size > maxCaptureRecordBytes {
+		return frame{}, fmt.Errorf("capture record of %d bytes exceeds limit", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return frame{}, err
+	}
+	if !p.nanos {
+		frac *= 1000
+	}
+	return frame{ts: time.Unix(sec, frac).UTC(), linkType: p.linkType, data: data}, nil
+}
+
+type pcapngInterfaceInfo struct {
+	linkType uint16
+	// unitsPerSecond is the timestamp resolution.
+	unitsPerSecond uint64
+}
+
+type pcapngReader struct {
+	r          io.Reader
+	order      binary.ByteOrder
+	interfaces []pcapngInterfaceInfo
+}
+
+func (p *pcapngReader) next() (frame, error) {
+	for {
+		head := make([]byte, 8)
+		if _, err := io.ReadFull(p.r, head); err != nil {
+			return frame{}, err
+		}
+
+		blockType := binary.LittleEndian.Uint32(head)
+		if blockType == pcapngSectionHeader {
+			// The byte order magic follows the block length and decides how the length is read.
+			bom := make([]byte, 4)
+			if _, err := io.ReadFull(p.r, bom); err != nil {
+				return frame{}, err
+			}
+			if binary.LittleEndian.Uint32(bom) == pcapngByteOrderMagic {
+				p.order = binary.LittleEndian
+			} else if binary.BigEndian.Uint32(bom) == pcapngByteOrderMagic {
+				p.order = binary.BigEndian
+			} else {
+				return frame{}, ErrUnknownFormat
+			}
+			p.interfaces = nil
+			total := p.order.Uint32(head[4:])
+			if total < 16 || total > maxCaptureRecordBytes {
+				return frame{}, ErrUnknownFormat
+			}
+			if _, err := io.CopyN(io.Discard, p.r, int64(total-12)); err != nil {
+				return frame{}, err
+			}
+			continue
+		}
+		if p.order == nil {
+			return frame{}, ErrUnknownFormat
+		}
+
+		blockType = p.order.Uint32(head)
+		total := p.order.Uint32(head[4:])
+		if total < 12 || total > maxCaptureRecordBytes {
+			return frame{}, fmt.Errorf("invalid pcapng block length %d", total)
+		}
+		body := make([]byte, total-8)
+		if _, err := io.ReadFull(p.r, body); err != nil {
+			return frame{}, err
+		}
+		body = body[:len(body)-4]
+
+		switch blockType {
+		case pcapngInterface:
+			if len(body) < 8 {
+				return frame{}, ErrUnknownFormat
+			}
+			p.interfaces = append(p.interfaces, pcapngInterfaceInfo{
+				linkType:       p.order.Uint16(body),
+				unitsPerSecond: p.tsResolution(body[8:]),
+			})
+		case pcapngEnhancedPacket:
+			if len(body) < 20 {
+				return frame{}, ErrUnknownFormat
+			}
+			id := p.order.Uint32(body)
+			if int(id) >= len(p.interfaces) {
+				return frame{}, fmt.Errorf("packet references unknown interface %d", id)
+			}
+			iface := p.interfaces[id]
+			ts := uint64(p.order.Uint32(body[4:]))<<32 | uint64(p.order.Uint32(body[8:]))
+			size := p.order.Uint32(body[12:])
+			if int(size) > len(body)-20 {
+				return frame{}, ErrUnknownFormat
+			}
+			hi, lo := bits.Mul64(ts%iface.unitsPerSecond, 1e9)
+			nanos, _ := bits.Div64(hi, lo, iface.unitsPerSecond)
+			return frame{
+				ts:       time.Unix(int64(ts/iface.unitsPerSecond), int64(nanos)).UTC(),
+				linkType: iface.linkType,
+				data:     body[20 : 20+size],
+			}, nil
+		case pcapngSimplePacket:
+			if len(body) < 4 || len(p.interfaces) == 0 {
+				return frame{}, ErrUnknownFormat
+			}
+			size := p.order.Uint32(body)
+			if int(size) > len(body)-4 {
+				size = uint32(len(body) - 4)
+			}
+			return frame{linkType: p.interfaces[0].linkType, data: body[4 : 4+size]}, nil
+		}
+	}
+}
+
+func (p *pcapngReader) tsResolution(options []byte) uint64 {
+	for len(options) >= 4 {
+		code := p.order.Uint16(options)
+		length := int(p.order.Uint16(options[2:]))
+		if code == 0 || 4+length > len(options) {
+			break
+		}
+		if code == pcapngOptionTsResol && length >= 1 {
+			v := options[4]
+			if v&0x80 == 0 && v <= 19 {
+				result := uint64(1)
+				for ; v > 0; v-- {
+					result *= 10
+				}
+				return result
+			}
+			if v&0x80 != 0 && v&0x7F <= 63 {
+				return 1 << (v & 0x7F)
+			}
+		}
+		options = options[4+(length+3)/4*4:]
+	}
+	return 1000000
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+type endpoint struct {
+	ip   string
+	port uint16
+}
+
+func (e endpoint) String() string {
+	return net.JoinHostPort(e.ip, fmt.Sprint(e.port))
+}
+
+type segment struct {
+	ts      time.Time
+	src     endpoint
+	dst     endpoint
+	seq     uint32
+	syn     bool
+	payload []byte
+}
+
+// decodeFrame extracts a TCP segment from a frame. ok is false for anything other than TCP over IPv4 or IPv6.
+func decodeFrame(f frame) (segment, bool) {
+	data := f.data
+	var etherType uint16
+	switch f.linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return segment{}, false
+		}
+		etherType = binary.BigEndian.Uint16(data[12:])
+		data = data[14:]
+		for etherType == 0x8100 || etherType == 0x88A8 {
+			if len(data) < 4 {
+				return segment{}, false
+			}
+			etherType = binary.BigEndian.Uint16(data[2:])
+			data = data[4:]
+		}
+	case linkTypeNull, linkTypeLoop:
+		if len(data) < 4 {
+			return segment{}, false
+		}
+		data = data[4:]
+	case linkTypeRaw, linkTypeRawAlt:
+	case linkTypeSll:
+		if len(data) < 16 {
+			return segment{}, false
+		}
+		etherType = binary.BigEndian.Uint16(data[14:])
+		data = data[16:]
+	case linkTypeSll2:
+		if len(data) < 20 {
+			return segment{}, false
+		}
+		etherType = binary.BigEndian.Uint16(data)
+		data = data[20:]
+	default:
+		return segment{}, false
+	}
+	if len(data) == 0 {
+		return segment{}, false
+	}
+	if etherType == 0 {
+		etherType = map[byte]uint16{4: 0x0800, 6: 0x86DD}[data[0]>>4]
+	}
+
+	var src, dst net.IP
+	switch etherType {
+	case 0x0800:
+		if len(data) < 20 || data[9] != 6 {
+			return segment{}, false
+		}
+		ihl := int(data[0]&0x0F) * 4
+		total := int(binary.BigEndian.Uint16(data[2:]))
+		if binary.BigEndian.Uint16(data[6:])&0x3FFF != 0 || ihl < 20 || total < ihl || total > len(data) {
+			return segment{}, false
+		}
+		src, dst = net.IP(data[12:16]), net.IP(data[16:20])
+		data = data[ihl:total]
+	case 0x86DD:
+		if len(data) < 40 || data[6] != 6 {
+			return segment{}, false
+		}
+		length := int(binary.BigEndian.Uint16(data[4:]))
+		if 40+length > len(data) {
+			return segment{}, false
+		}
+		src, dst = net.IP(data[8:24]), net.IP(data[24:40])
+		data = data[40 : 40+length]
+	default:
+		return segment{}, false
+	}
+
+	if len(data) < 20 {
+		return segment{}, false
+	}
+	offset := int(data[12]>>4) * 4
+	if offset < 20 || offset > len(data) {
+		return segment{}, false
+	}
+	return segment{
+		ts:      f.ts,
+		src:     endpoint{ip: src.String(), port: binary.BigEndian.Uint16(data[0:])},
+		dst:     endpoint{ip: dst.String(), port: binary.BigEndian.Uint16(data[2:])},
+		seq:     binary.BigEndian.Uint32(data[4:]),
+		syn:     data[13]&0x02 != 0,
+		payload: data[offset:],
+	}, true
+}
+
+// stream reassembles one direction of a TCP connection, delivering contiguous bytes in order.
+type stream struct {
+	started bool
+	next    uint32
+	pending map[uint32]segment
+	deliver func(ts time.Time, data []byte)
+}
+
+func newStream(deliver func(ts time.Time, data []byte)) *stream {
+	return &stream{pending: make(map[uint32]segment), deliver: deliver}
+}
+
+// maxPendingSegments bounds out of order buffering for streams with capture loss.
+const maxPendingSegments = 4096
+
+func (s *stream) add(seg segment) {
+	if seg.syn {
+		s.started = true
+		s.next = seg.seq + 1
+		return
+	}
+	if len(seg.payload) == 0 {
+		return
+	}
+	if !s.started {
+		s.started = true
+		s.next = seg.seq
+	}
+
+	if int32(seg.seq-s.next) > 0 {
+		// Of two segments at the same sequence number, such as a retransmission carrying more data, keep the longer.
+		if p, ok := s.pending[seg.seq]; ok && len(p.payload) >= len(seg.payload) {
+			return
+		}
+		if len(s.pending) < maxPendingSegments {
+			s.pending[seg.seq] = seg
+		}
+		return
+	}
+
+	s.pending[seg.seq] = seg
+	s.drain()
+}
+
+// drain delivers every buffered segment which starts at or before next. Overlap with data already delivered is trimmed, so a retransmission spanning a gap fills it.
+func (s *stream) drain() {
+	for delivered := true; delivered; {
+		delivered = false
+		for seq, p := range s.pending {
+			overlap := -int32(seq - s.next)
+			if overlap < 0 {
+				continue
+			}
+			delete(s.pending, seq)
+			if int(overlap) >= len(p.payload) {
+				continue
+			}
+			s.deliver(p.ts, p.payload[overlap:])
+			s.next += uint32(len(p.payload) - int(overlap))
+			delivered = true
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"github.com/Chronicle20/atlas-socket/response"
+	"github.com/Chronicle20/atlas-socket/sockettest"
+	"github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"time"
+)
+
+// Options configures a load run.
+type Options struct {
+	Target string
+	// Clients is the number of simulated clients.
+	Clients int
+	// Rate is the number of clients started per second, zero starts every client at once.
+	Rate float64
+	// Iterations is the number of times each client runs the script.
+	Iterations int
+	// Interval is the pause between steps, in addition to any step delay.
+	Interval time.Duration
+	Steps    []compiledStep
+	Crypto   []crypto.Configurator
+}
+
+// Run drives the simulated clients until each has finished its script or ctx is done.
+func Run(ctx context.Context, l logrus.FieldLogger, o Options) Report {
+	c := newCollector()
+	start := time.Now()
+
+	var ticker *time.Ticker
+	if o.Rate > 0 {
+		ticker = time.NewTicker(time.Duration(float64(time.Second) / o.Rate))
+		defer ticker.Stop()
+	}
+
+	var wg sync.WaitGroup
+	started := 0
+	for ; started < o.Clients; started++ {
+		if ticker != nil && started > 0 {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(client int) {
+			defer wg.Done()
+			simulate(ctx, l, o, c, client)
+		}(started)
+	}
+	wg.Wait()
+	return c.report(started, time.Since(start))
+}
+
+func simulate(ctx context.Context, l logrus.FieldLogger, o Options, c *collector, client int) {
+	begin := time.Now()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", o.Target)
+	if err != nil {
+		c.fail(ErrorConnect)
+		return
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+
+	sc, err := sockettest.Connect(conn, sockettest.WithLogger(l), sockettest.WithCrypto(o.Crypto...))
+	if err != nil {
+		_ = conn.Close()
+		c.fail(ErrorHandshake)
+		return
+	}
+	defer sc.Close()
+	c.observe("handshake", time.Since(begin))
+
+	for i := 0; i < max(o.Iterations, 1); i++ {
+		for _, st := range o.Steps {
+			if !pause(ctx, st.delay+o.Interval) {
+				return
+			}
+			if !step(l, sc, c, st, client) {
+				return
+			}
+		}
+	}
+	c.complete()
+}
+
+func pause(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func step(l logrus.FieldLogger, sc *sockettest.Client, c *collector, st compiledStep, client int) bool {
+	body := make([]func(w *response.Writer), 0, len(st.body))
+	for _, b := range st.body {
+		b := b
+		body = append(body, func(w *response.Writer) {
+			b(w, client)
+		})
+	}
+
+	sent := time.Now()
+	if err := sc.Send(st.op, body...); err != nil {
+		c.fail(ErrorSend)
+		return false
+	}
+	if st.expect == nil {
+		c.observe(st.name, time.Since(sent))
+		return true
+	}
+	if _, err := sc.ExpectOp(*st.expect, st.timeout); err != nil {
+		if errors.Is(err, sockettest.ErrTimeout) {
+			c.fail(ErrorTimeout)
+		} else {
+			c.fail(ErrorClosed)
+		}
+		l.WithError(err).Debugf("Client [%d] failed step [%s].", client, st.name)
+		return false
+	}
+	c.observe(st.name, time.Since(sent))
+	return true
+}
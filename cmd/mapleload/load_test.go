@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/Chronicle20/atlas-socket"
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"github.com/Chronicle20/atlas-socket/request"
+	"github.com/Chronicle20/atlas-socket/response"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testLogger() logrus.FieldLogger {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	return l
+}
+
+// startLoginServer answers op 0x0001 with 0x0000 and records each login name. Op 0x0002 is never answered.
+func startLoginServer(t *testing.T, l logrus.FieldLogger, names *sync.Map) *socket.Server {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s *socket.Server
+	s = socket.New(
+		socket.SetCryptoProfile(crypto.CryptoProfile{Region: crypto.RegionGMS, Version: 83}),
+		socket.SetLogger(l),
+		socket.SetListener(lis),
+		socket.SetReadWriter(socket.ShortReadWriter{}),
+		socket.SetHandlers(func() map[uint16]request.Handler {
+			return map[uint16]request.Handler{0x0001: func(sessionId uuid.UUID, r request.Reader) {
+				names.Store(r.ReadAsciiString(), r.ReadUint32())
+				w := response.NewWriter(l)
+				w.WriteShort(0x0000)
+				_ = s.Write(sessionId, w.Bytes())
+			}}
+		}))
+	if err = s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = s.Shutdown(context.Background())
+	})
+	return s
+}
+
+func TestRunAgainstServer(t *testing.T) {
+	l := testLogger()
+	names := &sync.Map{}
+	s := startLoginServer(t, l, names)
+
+	steps, err := ReadScript(strings.NewReader(`{"steps": [
+		{"name": "login", "op": "0x0001", "expect": "0x0000", "fields": [
+			{"type": "string", "value": "user{client}"},
+			{"type": "int", "value": 7}]}
+	]}`))
+	if err != nil {
+		t.Fatalf("Unable to read script: %s", err)
+	}
+
+	const clients = 50
+	r := Run(context.Background(), l, Options{Target: s.Addr().String(), Clients: clients, Rate: 1000, Iterations: 2, Steps: steps})
+	if r.Completed != clients || len(r.Errors) != 0 {
+		t.Fatalf("Expected every client to complete, got [%+v].", r)
+	}
+	if r.Latencies["login"].Count != clients*2 || r.Latencies["handshake"].Count != clients {
+		t.Errorf("Unexpected latency counts [%+v].", r.Latencies)
+	}
+	for i := 0; i < clients; i++ {
+		if v, ok := names.Load(fmt.Sprintf("user%d", i)); !ok || v != uint32(7) {
+			t.Errorf("Login for client [%d] not received intact.", i)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	r.Write(out)
+	if !strings.Contains(out.String(), "login") || !strings.Contains(out.String(), "none") {
+		t.Errorf("Unexpected report:\n%s", out.String())
+	}
+}
+
+func TestRunCountsErrors(t *testing.T) {
+	l := testLogger()
+	s := startLoginServer(t, l, &sync.Map{})
+
+	steps, err := ReadScript(strings.NewReader(`{"steps": [{"op": "2", "expect": "3", "timeout": "50ms"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Run(context.Background(), l, Options{Target: s.Addr().String(), Clients: 3, Steps: steps})
+	if r.Completed != 0 || r.Errors[ErrorTimeout] != 3 {
+		t.Errorf("Expected three timeouts, got [%+v].", r)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	_ = lis.Close()
+	r = Run(context.Background(), l, Options{Target: addr, Clients: 2})
+	if r.Errors[ErrorConnect] != 2 {
+		t.Errorf("Expected two connect errors, got [%+v].", r)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	samples := make([]time.Duration, 100)
+	for i := range samples {
+		samples[i] = time.Duration(100-i) * time.Millisecond
+	}
+	s := summarize(samples)
+	if s.P50 != 50*time.Millisecond || s.P90 != 90*time.Millisecond || s.P99 != 99*time.Millisecond || s.Max != 100*time.Millisecond {
+		t.Errorf("Unexpected summary [%+v].", s)
+	}
+}
+
+func TestScriptValidation(t *testing.T) {
+	for _, doc := range []string{
+		`{"steps": [{"op": "zz"}]}`,
+		`{"steps": [{"op": "1", "expect": "x"}]}`,
+		`{"steps": [{"op": "1", "fields": [{"type": "float", "value": 1}]}]}`,
+		`{"steps": [{"op": "1", "fields": [{"type": "int", "value": "a"}]}]}`,
+		`{"steps": [{"op": "1", "timeout": "soon"}]}`,
+		`{"steps": [{"op": "1", "unknown": true}]}`,
+	} {
+		if _, err := ReadScript(strings.NewReader(doc)); err == nil {
+			t.Errorf("Expected script [%s] to be rejected.", doc)
+		}
+	}
+}
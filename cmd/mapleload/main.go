@@ -0,0 +1,75 @@
+// Command mapleload measures how many concurrent sessions a server sustains.
+//
+// Each simulated client connects, completes the handshake and runs a script of packets, waiting for the responses it expects. Latency percentiles per step and error counts are reported once every client has finished.
+//
+//	mapleload -target 127.0.0.1:8484 -clients 2000 -rate 200 -script login.json
+//
+// A script lists the steps to perform, for example a login:
+//
+//	{"steps": [
+//	  {"name": "login", "op": "0x0001", "expect": "0x0000", "timeout": "5s", "fields": [
+//	    {"type": "string", "value": "user{client}"},
+//	    {"type": "string", "value": "password"}]},
+//	  {"name": "worlds", "op": "0x000B", "expect": "0x000A", "delay": "500ms"}]}
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	target := flag.String("target", "127.0.0.1:8484", "address of the server under test")
+	clients := flag.Int("clients", 100, "number of simulated clients")
+	rate := flag.Float64("rate", 0, "clients started per second, 0 starts all at once")
+	iterations := flag.Int("iterations", 1, "times each client runs the script")
+	interval := flag.Duration("interval", 0, "pause between steps")
+	duration := flag.Duration("duration", 0, "stop the run after this long, 0 runs to completion")
+	scriptPath := flag.String("script", "", "JSON script of steps, only the handshake is performed when empty")
+	region := flag.String("region", string(crypto.RegionGMS), "region used to look up the user key")
+	version := flag.Uint("version", 83, "client version used to look up the user key")
+	verbose := flag.Bool("v", false, "log individual client failures")
+	flag.Parse()
+
+	l := logrus.New()
+	if *verbose {
+		l.SetLevel(logrus.DebugLevel)
+	}
+
+	o := Options{Target: *target, Clients: *clients, Rate: *rate, Iterations: *iterations, Interval: *interval}
+	if *scriptPath != "" {
+		steps, err := LoadScript(*scriptPath)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "mapleload: %s\n", err)
+			os.Exit(1)
+		}
+		o.Steps = steps
+	}
+	key, err := crypto.LookupKey(crypto.Region(*region), uint16(*version))
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "mapleload: %s\n", err)
+		os.Exit(1)
+	}
+	o.Crypto = []crypto.Configurator{crypto.SetKey(key)}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if *duration > 0 {
+		var c context.CancelFunc
+		ctx, c = context.WithTimeout(ctx, *duration)
+		defer c()
+	}
+
+	l.Infof("Starting [%d] clients against [%s].", o.Clients, o.Target)
+	start := time.Now()
+	r := Run(ctx, l, o)
+	l.Infof("Finished after [%s].", time.Since(start).Round(time.Millisecond))
+	r.Write(os.Stdout)
+}
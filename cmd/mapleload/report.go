@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ErrorConnect   = "connect"
+	ErrorHandshake = "handshake"
+	ErrorSend      = "send"
+	ErrorTimeout   = "timeout"
+	ErrorClosed    = "closed"
+)
+
+// Summary describes the latency distribution of one step.
+type Summary struct {
+	Count int
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// Report is the outcome of a load run. Steps lists latency names in the order first observed.
+type Report struct {
+	Steps     []string
+	Clients   int
+	Completed int
+	Elapsed   time.Duration
+	Latencies map[string]Summary
+	Errors    map[string]int
+}
+
+// collector gathers samples and errors from every client.
+type collector struct {
+	mu        sync.Mutex
+	order     []string
+	samples   map[string][]time.Duration
+	errors    map[string]int
+	completed int
+}
+
+func newCollector() *collector {
+	return &collector{samples: make(map[string][]time.Duration), errors: make(map[string]int)}
+}
+
+func (c *collector) observe(name string, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.samples[name]; !ok {
+		c.order = append(c.order, name)
+	}
+	c.samples[name] = append(c.samples[name], d)
+}
+
+func (c *collector) fail(kind string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errors[kind]++
+}
+
+func (c *collector) complete() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.completed++
+}
+
+func (c *collector) report(clients int, elapsed time.Duration) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := Report{Steps: append([]string(nil), c.order...), Clients: clients, Completed: c.completed, Elapsed: elapsed, Latencies: make(map[string]Summary), Errors: make(map[string]int)}
+	for name, s := range c.samples {
+		r.Latencies[name] = summarize(s)
+	}
+	for k, v := range c.errors {
+		r.Errors[k] = v
+	}
+	return r
+}
+
+func summarize(samples []time.Duration) Summary {
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	return Summary{
+		Count: len(sorted),
+		P50:   percentile(sorted, 50),
+		P90:   percentile(sorted, 90),
+		P99:   percentile(sorted, 99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// percentile uses the nearest rank method over sorted samples.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Write renders the report as a plain text table.
+func (r Report) Write(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "clients %d, completed %d, elapsed %s\n\n", r.Clients, r.Completed, r.Elapsed.Round(time.Millisecond))
+	_, _ = fmt.Fprintf(w, "%-20s %8s %12s %12s %12s %12s\n", "step", "count", "p50", "p90", "p99", "max")
+	for _, name := range r.Steps {
+		s, ok := r.Latencies[name]
+		if !ok {
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%-20s %8d %12s %12s %12s %12s\n", name, s.Count, s.P50, s.P90, s.P99, s.Max)
+	}
+
+	kinds := make([]string, 0, len(r.Errors))
+	for k := range r.Errors {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	_, _ = fmt.Fprintf(w, "\nerrors\n")
+	if len(kinds) == 0 {
+		_, _ = fmt.Fprintf(w, "  none\n")
+	}
+	for _, k := range kinds {
+		_, _ = fmt.Fprintf(w, "  %-12s %d\n", k, r.Errors[k])
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Chronicle20/atlas-socket/capture"
+	"github.com/Chronicle20/atlas-socket/response"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration written as a string such as "250ms" in scripts.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Field is one value written to a packet body. Type is one of byte, short, int, long, bool, string or bytes. String values may contain {client}, which is replaced by the index of the simulated client.
+type Field struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Step sends a packet and, when Expect is set, waits for a packet with that op. Its latency is recorded under Name.
+type Step struct {
+	Name    string   `json:"name"`
+	Op      string   `json:"op"`
+	Fields  []Field  `json:"fields"`
+	Expect  string   `json:"expect"`
+	Timeout Duration `json:"timeout"`
+	Delay   Duration `json:"delay"`
+}
+
+// Script is the sequence of steps each simulated client performs after the handshake.
+type Script struct {
+	Steps []Step `json:"steps"`
+}
+
+// compiledStep is a validated step ready to be sent.
+type compiledStep struct {
+	name    string
+	op      uint16
+	body    []func(w *response.Writer, client int)
+	expect  *uint16
+	timeout time.Duration
+	delay   time.Duration
+}
+
+const defaultStepTimeout = 5 * time.Second
+
+// ReadScript reads and validates a JSON script.
+func ReadScript(r io.Reader) ([]compiledStep, error) {
+	var s Script
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return nil, err
+	}
+	return s.compile()
+}
+
+// LoadScript reads the script at path.
+func LoadScript(path string) ([]compiledStep, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadScript(f)
+}
+
+func (s Script) compile() ([]compiledStep, error) {
+	result := make([]compiledStep, 0, len(s.Steps))
+	for i, st := range s.Steps {
+		c, err := st.compile()
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %w", i, err)
+		}
+		if c.name == "" {
+			c.name = fmt.Sprintf("0x%04X", c.op)
+		}
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+func (st Step) compile() (compiledStep, error) {
+	op, err := parseOp(st.Op)
+	if err != nil {
+		return compiledStep{}, err
+	}
+	c := compiledStep{name: st.Name, op: op, timeout: time.Duration(st.Timeout), delay: time.Duration(st.Delay)}
+	if c.timeout == 0 {
+		c.timeout = defaultStepTimeout
+	}
+	if st.Expect != "" {
+		e, err := parseOp(st.Expect)
+		if err != nil {
+			return compiledStep{}, err
+		}
+		c.expect = &e
+	}
+	for _, f := range st.Fields {
+		w, err := f.compile()
+		if err != nil {
+			return compiledStep{}, err
+		}
+		c.body = append(c.body, w)
+	}
+	return c, nil
+}
+
+func parseOp(s string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid op [%s]: %w", s, err)
+	}
+	return uint16(v), nil
+}
+
+func (f Field) compile() (func(w *response.Writer, client int), error) {
+	switch f.Type {
+	case "byte", "short", "int", "long":
+		var v int64
+		if err := json.Unmarshal(f.Value, &v); err != nil {
+			return nil, fmt.Errorf("invalid %s value [%s]: %w", f.Type, f.Value, err)
+		}
+		switch f.Type {
+		case "byte":
+			return func(w *response.Writer, _ int) { w.WriteByte(byte(v)) }, nil
+		case "short":
+			return func(w *response.Writer, _ int) { w.WriteShort(uint16(v)) }, nil
+		case "int":
+			return func(w *response.Writer, _ int) { w.WriteInt(uint32(v)) }, nil
+		}
+		return func(w *response.Writer, _ int) { w.WriteLong(uint64(v)) }, nil
+	case "bool":
+		var v bool
+		if err := json.Unmarshal(f.Value, &v); err != nil {
+			return nil, fmt.Errorf("invalid bool value [%s]: %w", f.Value, err)
+		}
+		return func(w *response.Writer, _ int) { w.WriteBool(v) }, nil
+	case "string":
+		var v string
+		if err := json.Unmarshal(f.Value, &v); err != nil {
+			return nil, fmt.Errorf("invalid string value [%s]: %w", f.Value, err)
+		}
+		return func(w *response.Writer, client int) {
+			w.WriteAsciiString(strings.ReplaceAll(v, "{client}", strconv.Itoa(client)))
+		}, nil
+	case "bytes":
+		var v capture.Bytes
+		if err := json.Unmarshal(f.Value, &v); err != nil {
+			return nil, fmt.Errorf("invalid bytes value [%s]: %w", f.Value, err)
+		}
+		return func(w *response.Writer, _ int) { w.WriteByteArray(v) }, nil
+	}
+	return nil, fmt.Errorf("unknown field type [%s]", f.Type)
+}
@@ -0,0 +1,91 @@
+// Command mapleproxy relays MapleStory traffic between a client and an upstream server for protocol research.
+//
+// The handshake sent by the upstream is intercepted to learn both IVs, after which every packet is decrypted, logged with its opcode name, passed through the rules in the -rules file and re-encrypted for the other side.
+//
+//	mapleproxy -listen :8484 -upstream 10.0.0.5:8484 -ops opcodes.json -rules rules.json
+//
+// A rules file is a JSON array applied in order, for example:
+//
+//	[{"dir": "in", "op": "0x0001", "action": "patch", "offset": 2, "data": "0100"},
+//	 {"dir": "out", "op": "0x0011", "action": "drop"}]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/Chronicle20/atlas-socket/capture"
+	"github.com/Chronicle20/atlas-socket/cmd/internal/codec"
+	"github.com/Chronicle20/atlas-socket/cmd/internal/opcode"
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	listen := flag.String("listen", ":8484", "address to accept client connections on")
+	upstream := flag.String("upstream", "", "address of the server to relay to")
+	opsPath := flag.String("ops", "", "JSON opcode table used to name packets")
+	rulesPath := flag.String("rules", "", "JSON rules used to drop or modify packets")
+	capturePath := flag.String("capture", "", "write forwarded packets as capture JSONL to this file")
+	region := flag.String("region", string(crypto.RegionGMS), "region used to look up the user key")
+	scheme := flag.String("scheme", "aesofb", "cipher scheme, one of aesofb, shanda or plaintext")
+	op := flag.String("op", "short", "op encoding, short or byte")
+	quiet := flag.Bool("quiet", false, "do not log individual packets")
+	flag.Parse()
+
+	l := logrus.New()
+	if err := run(l, *listen, *upstream, *opsPath, *rulesPath, *capturePath, crypto.Region(*region), *scheme, *op, *quiet); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "mapleproxy: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(l logrus.FieldLogger, listen string, upstream string, opsPath string, rulesPath string, capturePath string, region crypto.Region, scheme string, op string, quiet bool) error {
+	if upstream == "" {
+		return fmt.Errorf("an upstream address is required")
+	}
+	s, err := codec.Scheme(scheme)
+	if err != nil {
+		return err
+	}
+	ops, err := codec.OpReader(op)
+	if err != nil {
+		return err
+	}
+	table, err := opcode.Load(opsPath)
+	if err != nil {
+		return err
+	}
+	rules, err := LoadRules(rulesPath)
+	if err != nil {
+		return err
+	}
+
+	var hooks []Hook
+	if !quiet {
+		hooks = append(hooks, LogHook(l, table.Name))
+	}
+	hooks = append(hooks, rules...)
+	if capturePath != "" {
+		f, err := os.Create(capturePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		hooks = append(hooks, CaptureHook(capture.NewJSONLWriter(f)))
+	}
+
+	lis, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	l.Infof("Relaying connections on [%s] to [%s].", lis.Addr(), upstream)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	return NewProxy(l, upstream, crypto.CryptoProfile{Region: region, Scheme: s}, ops, hooks...).Serve(ctx, lis)
+}
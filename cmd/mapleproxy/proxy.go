@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Chronicle20/atlas-socket"
+	"github.com/Chronicle20/atlas-socket/capture"
+	"github.com/Chronicle20/atlas-socket/cmd/internal/codec"
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxPacketBytes bounds the length announced by a header before the body is read.
+const maxPacketBytes = 1 << 20
+
+// Packet is a decrypted packet in flight. Data holds the full packet, op included. Hooks may modify Data in place or replace it.
+type Packet struct {
+	SessionId uuid.UUID
+	Direction capture.Direction
+	Op        uint16
+	Data      []byte
+}
+
+// Hook inspects a packet before it is re-encrypted and forwarded. Returning false drops the packet.
+type Hook func(p *Packet) bool
+
+// Proxy sits between a client and an upstream server, decrypting and re-encrypting traffic in both directions.
+type Proxy struct {
+	l        logrus.FieldLogger
+	upstream string
+	profile  crypto.CryptoProfile
+	ops      socket.OpReader
+	hooks    []Hook
+	dialer   net.Dialer
+	wg       sync.WaitGroup
+}
+
+func NewProxy(l logrus.FieldLogger, upstream string, profile crypto.CryptoProfile, ops socket.OpReader, hooks ...Hook) *Proxy {
+	return &Proxy{l: l, upstream: upstream, profile: profile, ops: ops, hooks: hooks, dialer: net.Dialer{Timeout: 10 * time.Second}}
+}
+
+// Serve accepts client connections until ctx is done, then waits for open connections to end.
+func (p *Proxy) Serve(ctx context.Context, lis net.Listener) error {
+	stop := context.AfterFunc(ctx, func() {
+		_ = lis.Close()
+	})
+	defer stop()
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			p.wg.Wait()
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.handle(ctx, conn)
+		}()
+	}
+}
+
+func (p *Proxy) handle(ctx context.Context, client net.Conn) {
+	sessionId := uuid.New()
+	l := p.l.WithField("session", sessionId.String())
+	defer client.Close()
+
+	server, err := p.dialer.DialContext(ctx, "tcp", p.upstream)
+	if err != nil {
+		l.WithError(err).Errorf("Unable to connect to upstream [%s].", p.upstream)
+		return
+	}
+	defer server.Close()
+	l.Infof("Proxying [%s] to [%s].", client.RemoteAddr(), p.upstream)
+
+	_ = server.SetReadDeadline(time.Now().Add(10 * time.Second))
+	h, err := crypto.ReadHandshake(server)
+	_ = server.SetReadDeadline(time.Time{})
+	if err != nil {
+		l.WithError(err).Errorf("Unable to read handshake from upstream.")
+		return
+	}
+	l.Infof("Intercepted handshake for version [%d.%s] locale [%d], recv IV [% X] send IV [% X].", h.Version, h.Patch, h.Locale, h.RecvIv, h.SendIv)
+	if _, err = client.Write(h.Bytes()); err != nil {
+		l.WithError(err).Errorf("Unable to forward handshake to client.")
+		return
+	}
+
+	inbound, err := p.leg(h.RecvIv, h.Version)
+	if err != nil {
+		l.WithError(err).Errorf("Unable to create client ciphers.")
+		return
+	}
+	outbound, err := p.leg(h.SendIv, 0xFFFF-h.Version)
+	if err != nil {
+		l.WithError(err).Errorf("Unable to create server ciphers.")
+		return
+	}
+
+	pctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-pctx.Done()
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer cancel()
+		p.pump(l, sessionId, capture.Inbound, client, server, inbound)
+	}()
+	go func() {
+		defer wg.Done()
+		defer cancel()
+		p.pump(l, sessionId, capture.Outbound, server, client, outbound)
+	}()
+	wg.Wait()
+	l.Infof("Closed proxied connection from [%s].", client.RemoteAddr())
+}
+
+// leg decrypts with one cipher and re-encrypts with an identically keyed one. Keeping them separate lets hooks drop packets without desynchronizing the receiving peer.
+type leg struct {
+	decrypt crypto.Cipher
+	encrypt crypto.Cipher
+}
+
+func (p *Proxy) leg(iv []byte, version uint16) (leg, error) {
+	pr := p.profile
+	pr.Version = version
+	d, err := pr.NewCipher(append([]byte(nil), iv...), version)
+	if err != nil {
+		return leg{}, err
+	}
+	e, err := pr.NewCipher(append([]byte(nil), iv...), version)
+	if err != nil {
+		return leg{}, err
+	}
+	return leg{decrypt: d, encrypt: e}, nil
+}
+
+func (p *Proxy) pump(l logrus.FieldLogger, sessionId uuid.UUID, dir capture.Direction, src io.Reader, dst io.Writer, lg leg) {
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(src, header); err != nil {
+			p.logEnd(l, dir, err)
+			return
+		}
+		length := lg.decrypt.PacketLength(header)
+		if length < 0 || length > maxPacketBytes {
+			l.Errorf("Invalid [%s] packet length [%d].", dir, length)
+			return
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(src, body); err != nil {
+			p.logEnd(l, dir, err)
+			return
+		}
+		crypto.DecryptPacket(lg.decrypt, body)
+
+		pkt := &Packet{SessionId: sessionId, Direction: dir, Op: codec.Op(p.ops, body), Data: body}
+		if !p.apply(pkt) {
+			continue
+		}
+
+		out := append(make([]byte, 4, 4+len(pkt.Data)), pkt.Data...)
+		if err := crypto.EncryptPacket(lg.encrypt, out); err != nil {
+			p.logEnd(l, dir, err)
+			return
+		}
+		if _, err := dst.Write(out); err != nil {
+			p.logEnd(l, dir, err)
+			return
+		}
+	}
+}
+
+func (p *Proxy) apply(pkt *Packet) bool {
+	for _, h := range p.hooks {
+		if !h(pkt) {
+			return false
+		}
+		pkt.Op = codec.Op(p.ops, pkt.Data)
+	}
+	return true
+}
+
+func (p *Proxy) logEnd(l logrus.FieldLogger, dir capture.Direction, err error) {
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		l.Debugf("The [%s] stream ended.", dir)
+		return
+	}
+	l.WithError(err).Debugf("The [%s] stream failed.", dir)
+}
+
+// LogHook logs every packet with its opcode name.
+func LogHook(l logrus.FieldLogger, name func(dir capture.Direction, op uint16) string) Hook {
+	return func(p *Packet) bool {
+		n := name(p.Direction, p.Op)
+		if n == "" {
+			n = fmt.Sprintf("0x%04X", p.Op)
+		}
+		l.WithField("session", p.SessionId.String()).Infof("[%s] [%s] len [%d] [% X].", p.Direction, n, len(p.Data), p.Data)
+		return true
+	}
+}
+
+// CaptureHook records packets as forwarded, after earlier hooks have run.
+func CaptureHook(r capture.Recorder) Hook {
+	return func(p *Packet) bool {
+		r.Record(capture.Record{Time: time.Now(), Direction: p.Direction, SessionId: p.SessionId, Op: p.Op, Data: append([]byte(nil), p.Data...)})
+		return true
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/Chronicle20/atlas-socket"
+	"github.com/Chronicle20/atlas-socket/capture"
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"github.com/Chronicle20/atlas-socket/request"
+	"github.com/Chronicle20/atlas-socket/response"
+	"github.com/Chronicle20/atlas-socket/sockettest"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testLogger() logrus.FieldLogger {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	return l
+}
+
+// startEchoServer runs the library server answering op 0x0001 with a notice 0x0003 followed by an echo 0x0002.
+func startEchoServer(t *testing.T, l logrus.FieldLogger) *socket.Server {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s *socket.Server
+	s = socket.New(
+		socket.SetCryptoProfile(crypto.CryptoProfile{Region: crypto.RegionGMS, Version: 83}),
+		socket.SetLogger(l),
+		socket.SetListener(lis),
+		socket.SetReadWriter(socket.ShortReadWriter{}),
+		socket.SetHandlers(func() map[uint16]request.Handler {
+			return map[uint16]request.Handler{0x0001: func(sessionId uuid.UUID, r request.Reader) {
+				msg := r.ReadAsciiString()
+				notice := response.NewWriter(l)
+				notice.WriteShort(0x0003)
+				_ = s.Write(sessionId, notice.Bytes())
+				echo := response.NewWriter(l)
+				echo.WriteShort(0x0002)
+				echo.WriteAsciiString(msg)
+				_ = s.Write(sessionId, echo.Bytes())
+			}}
+		}))
+	if err = s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = s.Shutdown(context.Background())
+	})
+	return s
+}
+
+func startProxy(t *testing.T, upstream string, hooks ...Hook) net.Addr {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- NewProxy(testLogger(), upstream, crypto.CryptoProfile{Region: crypto.RegionGMS}, socket.ShortReadWriter{}, hooks...).Serve(ctx, lis)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Proxy failed: %s", err)
+		}
+	})
+	return lis.Addr()
+}
+
+func TestProxyEndToEnd(t *testing.T) {
+	l := testLogger()
+	s := startEchoServer(t, l)
+
+	hooks, err := ReadRules(strings.NewReader(`[
+		{"dir": "in", "op": "0x0001", "action": "patch", "offset": 4, "data": "4a"},
+		{"dir": "out", "op": "3", "action": "drop"}
+	]`))
+	if err != nil {
+		t.Fatalf("Unable to read rules: %s", err)
+	}
+	rec := &recorder{}
+	addr := startProxy(t, s.Addr().String(), append(hooks, CaptureHook(rec))...)
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := sockettest.Connect(conn, sockettest.WithLogger(l))
+	if err != nil {
+		t.Fatalf("Unable to complete handshake through proxy: %s", err)
+	}
+	defer c.Close()
+	if c.Handshake().Version != 83 {
+		t.Errorf("Handshake version [%d] was not relayed.", c.Handshake().Version)
+	}
+
+	for _, msg := range []string{"hello", "world"} {
+		if err = c.Send(0x0001, func(w *response.Writer) {
+			w.WriteAsciiString(msg)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case p := <-c.Packets():
+			if p.Op != 0x0002 {
+				t.Fatalf("Dropped op 0x0003 reached the client, got op [0x%04X].", p.Op)
+			}
+			r := p.Reader()
+			if actual, expected := r.ReadAsciiString(), "J"+msg[1:]; actual != expected {
+				t.Errorf("Expected patched echo [%s], got [%s].", expected, actual)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("No echo received for [%s].", msg)
+		}
+	}
+
+	records := rec.all()
+	if len(records) != 4 {
+		t.Fatalf("Captured [%d] forwarded packets, expected 4.", len(records))
+	}
+	if records[0].Direction != capture.Inbound || !bytes.Equal(records[0].Data[4:], []byte("Jello")) {
+		t.Errorf("Unexpected first record [%+v].", records[0])
+	}
+}
+
+func TestRuleValidation(t *testing.T) {
+	for _, doc := range []string{
+		`[{"action": "explode"}]`,
+		`[{"op": "zz", "action": "drop"}]`,
+		`[{"dir": "sideways", "action": "drop"}]`,
+		`[{"action": "patch", "offset": -1}]`,
+	} {
+		if _, err := ReadRules(strings.NewReader(doc)); err == nil {
+			t.Errorf("Expected rules [%s] to be rejected.", doc)
+		}
+	}
+}
+
+func TestReplaceRule(t *testing.T) {
+	h, err := Rule{Action: ActionReplace, Data: []byte{0x05, 0x00, 0x01}}.Hook()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Packet{Direction: capture.Outbound, Op: 0x0002, Data: []byte{0x02, 0x00}}
+	px := &Proxy{ops: socket.ShortReadWriter{}, hooks: []Hook{h}}
+	if !px.apply(p) || p.Op != 0x0005 || !bytes.Equal(p.Data, []byte{0x05, 0x00, 0x01}) {
+		t.Errorf("Unexpected replaced packet [%+v].", p)
+	}
+}
+
+type recorder struct {
+	mu      sync.Mutex
+	records []capture.Record
+}
+
+func (r *recorder) Record(rec capture.Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, rec)
+}
+
+func (r *recorder) all() []capture.Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]capture.Record(nil), r.records...)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Chronicle20/atlas-socket/capture"
+	"io"
+	"os"
+	"strconv"
+)
+
+const (
+	ActionDrop    = "drop"
+	ActionReplace = "replace"
+	ActionPatch   = "patch"
+)
+
+// Rule is a scripted packet modification. An empty Direction or Op matches every packet. Replace swaps the whole packet, op included, for Data. Patch overwrites the packet from Offset with Data, growing it if needed.
+type Rule struct {
+	Direction capture.Direction `json:"dir"`
+	Op        string            `json:"op"`
+	Action    string            `json:"action"`
+	Offset    int               `json:"offset"`
+	Data      capture.Bytes     `json:"data"`
+}
+
+// ReadRules reads a JSON array of rules and compiles them into hooks, applied in order.
+func ReadRules(r io.Reader) ([]Hook, error) {
+	var rules []Rule
+	if err := json.NewDecoder(r).Decode(&rules); err != nil {
+		return nil, err
+	}
+	hooks := make([]Hook, 0, len(rules))
+	for i, rule := range rules {
+		h, err := rule.Hook()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// LoadRules reads the rules at path. An empty path yields no hooks.
+func LoadRules(path string) ([]Hook, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadRules(f)
+}
+
+func (r Rule) Hook() (Hook, error) {
+	if r.Direction != "" && r.Direction != capture.Inbound && r.Direction != capture.Outbound {
+		return nil, fmt.Errorf("invalid direction [%s]", r.Direction)
+	}
+	anyOp := r.Op == ""
+	var op uint16
+	if !anyOp {
+		v, err := strconv.ParseUint(r.Op, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid op [%s]: %w", r.Op, err)
+		}
+		op = uint16(v)
+	}
+	if r.Offset < 0 {
+		return nil, fmt.Errorf("invalid offset [%d]", r.Offset)
+	}
+
+	matches := func(p *Packet) bool {
+		return (r.Direction == "" || r.Direction == p.Direction) && (anyOp || op == p.Op)
+	}
+
+	switch r.Action {
+	case ActionDrop:
+		return func(p *Packet) bool {
+			return !matches(p)
+		}, nil
+	case ActionReplace:
+		return func(p *Packet) bool {
+			if matches(p) {
+				p.Data = append([]byte(nil), r.Data...)
+			}
+			return true
+		}, nil
+	case ActionPatch:
+		return func(p *Packet) bool {
+			if matches(p) {
+				if end := r.Offset + len(r.Data); end > len(p.Data) {
+					p.Data = append(p.Data, make([]byte, end-len(p.Data))...)
+				}
+				copy(p.Data[r.Offset:], r.Data)
+			}
+			return true
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown action [%s]", r.Action)
+}
@@ -3,6 +3,8 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/subtle"
+	"math/bits"
 	"os"
 )
 
@@ -11,7 +13,7 @@ const (
 	blockSize         = 1460
 )
 
-var key = []byte{0x13, 0x00, 0x00, 0x00, 0x08, 0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0xB4, 0x00, 0x00, 0x00, 0x1B, 0x00, 0x00, 0x00, 0x0F, 0x00, 0x00, 0x00, 0x33, 0x00, 0x00, 0x00, 0x52, 0x00, 0x00, 0x00}
+var key = DefaultCompactKey.Expand()
 
 type AESOFB struct {
 	key         []byte
@@ -19,6 +21,7 @@ type AESOFB struct {
 	ivGenerator IvGenerator
 	version     uint16
 	cipher      cipher.Block
+	keystream   []byte
 }
 
 func (a *AESOFB) IV() []byte {
@@ -49,74 +52,91 @@ func FillIvZeroGenerator(input []byte) []byte {
 }
 
 func (a *AESOFB) Encrypt(maple bool, aes bool) func(input []byte) []byte {
+	encrypt := a.EncryptInto(maple, aes)
 	return func(input []byte) []byte {
-		working := make([]byte, len(input))
-		copy(working, input)
+		return encrypt(make([]byte, len(input)), input)
+	}
+}
+
+// EncryptInto encrypts src, which has room reserved for the header in its first four bytes, into dst. dst is reused when it has sufficient capacity and may alias src. The encrypted packet is returned.
+func (a *AESOFB) EncryptInto(maple bool, aes bool) func(dst []byte, src []byte) []byte {
+	return func(dst []byte, src []byte) []byte {
+		dst = append(dst[:0], src...)
 
-		a.generateHeader(working)
+		a.generateHeader(dst)
 
 		if maple {
-			a.mapleCrypt(working[encryptHeaderSize:])
+			a.mapleCrypt(dst[encryptHeaderSize:])
 		}
 
 		if aes {
-			a.aesCrypt(working[encryptHeaderSize:])
+			a.aesCrypt(dst[encryptHeaderSize:])
 		}
 
 		a.Shuffle()
-		return working
+		return dst
 	}
 }
 
 type DecryptFunc func(input []byte) []byte
 
 func (a *AESOFB) Decrypt(aes bool, maple bool) func(input []byte) []byte {
+	decrypt := a.DecryptInPlace(aes, maple)
 	return func(input []byte) []byte {
 		working := make([]byte, len(input))
 		copy(working, input)
+		decrypt(working)
+		return working
+	}
+}
 
+// DecryptInPlace decrypts the packet body held in buf, overwriting it.
+func (a *AESOFB) DecryptInPlace(aes bool, maple bool) func(buf []byte) {
+	return func(buf []byte) {
 		if aes {
-			a.aesCrypt(working)
+			a.aesCrypt(buf)
 		}
 
 		if maple {
-			a.mapleDecrypt(working)
+			a.mapleDecrypt(buf)
 		}
 
 		a.Shuffle()
-		return working
 	}
 }
 
+// aesCrypt applies the AES-OFB keystream to input. Maple restarts the stream every 1460 bytes (less the header on the first block), so the keystream is generated once per packet from the expanded IV and reused for each block.
 func (a *AESOFB) aesCrypt(input []byte) {
-	var pos, tpos, cbwrite, cb int32 = 0, 0, 0, int32(len(input))
-	var first byte = 1
-
-	cb = int32(len(input))
-
-	// I'm not 100% sure what this exactly does but apparently maple
-	// decrypts packets in blocks of 1460 bytes to work around
-	// packets limitations or something
-
-	for cb > pos {
-		tpos = blockSize - int32(first*4)
-
-		if cb > pos+tpos {
-			cbwrite = tpos
-		} else {
-			cbwrite = cb - pos
+	ks := a.keystreamFor(min(len(input), blockSize))
+
+	first := true
+	for pos := 0; pos < len(input); {
+		n := blockSize
+		if first {
+			n -= encryptHeaderSize
+			first = false
 		}
+		end := min(pos+n, len(input))
+		subtle.XORBytes(input[pos:end], input[pos:end], ks[:end-pos])
+		pos += n
+	}
+}
 
-		myIv := a.ivGenerator(a.iv)
-		stream := cipher.NewOFB(a.cipher, myIv[:])
-		stream.XORKeyStream(input[pos:pos+cbwrite], input[pos:pos+cbwrite])
-
-		pos += tpos
+// keystreamFor fills the reusable keystream buffer with at least n bytes of OFB output for the current IV.
+func (a *AESOFB) keystreamFor(n int) []byte {
+	size := (n + aes.BlockSize - 1) / aes.BlockSize * aes.BlockSize
+	if cap(a.keystream) < size {
+		a.keystream = make([]byte, size)
+	}
+	ks := a.keystream[:size]
 
-		if first == 1 {
-			first = 0
-		}
+	block := a.ivGenerator(a.iv)
+	prev := block[:aes.BlockSize]
+	for off := 0; off < size; off += aes.BlockSize {
+		a.cipher.Encrypt(ks[off:off+aes.BlockSize], prev)
+		prev = ks[off : off+aes.BlockSize]
 	}
+	return ks
 }
 
 func multiplyBytes(input []byte, count int, mul int) []byte {
@@ -128,8 +148,16 @@ func multiplyBytes(input []byte, count int, mul int) []byte {
 	return working
 }
 
-// Taken from Kagami
 func (a *AESOFB) mapleCrypt(input []byte) {
+	shandaEncrypt(input)
+}
+
+func (a *AESOFB) mapleDecrypt(input []byte) {
+	shandaDecrypt(input)
+}
+
+// Taken from Kagami
+func shandaEncrypt(input []byte) {
 	var j int32
 	var b, c byte
 
@@ -163,7 +191,7 @@ func (a *AESOFB) mapleCrypt(input []byte) {
 	}
 }
 
-func (a *AESOFB) mapleDecrypt(input []byte) {
+func shandaDecrypt(input []byte) {
 	var j int32
 	var d, b, c byte
 
@@ -202,10 +230,15 @@ func (a *AESOFB) mapleDecrypt(input []byte) {
 }
 
 func (a *AESOFB) generateHeader(input []byte) {
+	writeHeader(input, a.iv, a.version)
+}
+
+// writeHeader fills the first four bytes of input from the iv, the byte swapped version and the body length.
+func writeHeader(input []byte, iv []byte, version uint16) {
 	bodyLength := int32(len(input[encryptHeaderSize:]))
-	iiv := int32(a.iv[3] & 255)
-	iiv |= int32(a.iv[2]) << 8 & '\uff00'
-	iiv ^= int32(a.version)
+	iiv := int32(iv[3] & 255)
+	iiv |= int32(iv[2]) << 8 & '\uff00'
+	iiv ^= int32(version)
 
 	mLen := bodyLength<<8&'\uff00' | bodyLength>>8
 	xoredIv := iiv ^ mLen
@@ -215,47 +248,24 @@ func (a *AESOFB) generateHeader(input []byte) {
 	input[3] = byte(xoredIv & 255)
 }
 
-// Taken from Kagami
 func ror(val byte, num int) byte {
-	for i := 0; i < num; i++ {
-		var lowbit int
-
-		if val&1 > 0 {
-			lowbit = 1
-		} else {
-			lowbit = 0
-		}
-
-		val >>= 1
-		val |= byte(lowbit << 7)
-	}
-
-	return val
+	return bits.RotateLeft8(val, -num)
 }
 
-// Taken from Kagami
 func rol(val byte, num int) byte {
-	var highBit int
-
-	for i := 0; i < num; i++ {
-		if val&0x80 > 0 {
-			highBit = 1
-		} else {
-			highBit = 0
-		}
-
-		val <<= 1
-		val |= byte(highBit)
-	}
-
-	return val
+	return bits.RotateLeft8(val, num)
 }
 
 func (a *AESOFB) Shuffle() {
+	shuffleIv(a.iv)
+}
+
+// shuffleIv rotates iv in place to the value used for the next packet.
+func shuffleIv(iv []byte) {
 	newIV := []byte{0xF2, 0x53, 0x50, 0xC6}
 
 	for i := 0; i < 4; i++ {
-		input := a.iv[i]
+		input := iv[i]
 		shiftVal := ivShiftKey[input]
 
 		newIV[0] += ivShiftKey[newIV[1]] - input
@@ -272,7 +282,7 @@ func (a *AESOFB) Shuffle() {
 		newIV[3] = byte(shift >> 24 & uint32(0xFF))
 	}
 
-	copy(a.iv[:], newIV[:])
+	copy(iv[:], newIV[:])
 }
 
 var ivShiftKey = [...]byte{
@@ -307,13 +317,18 @@ func SetIvGenerator(generator IvGenerator) Configurator {
 	}
 }
 
+// swapVersion byte swaps the version into the form xored into packet headers.
+func swapVersion(version uint16) uint16 {
+	return version>>8&255 | version<<8&uint16(uint32('\uff00'))
+}
+
 //goland:noinspection GoUnusedExportedFunction
 func NewAESOFB(iv []byte, version uint16, configurators ...Configurator) *AESOFB {
 	a := &AESOFB{
 		key:         key,
 		iv:          iv,
 		ivGenerator: DefaultIvGenerator,
-		version:     version>>8&255 | version<<8&uint16(uint32('\uff00')),
+		version:     swapVersion(version),
 	}
 
 	for _, configure := range configurators {
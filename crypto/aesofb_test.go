@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -58,3 +59,125 @@ func TestCryptGMSv83(t *testing.T) {
 
 	t.Log(working)
 }
+
+func TestEncryptIntoMatchesEncrypt(t *testing.T) {
+	for _, size := range []int{4, 5, 64, 1460, 1465, 4000} {
+		src := make([]byte, size)
+		for i := range src {
+			src[i] = byte(i * 7)
+		}
+
+		expected := NewAESOFB([]byte{0x01, 0x02, 0x03, 0x04}, 83).Encrypt(true, true)(src)
+
+		dst := make([]byte, 0, 16)
+		actual := NewAESOFB([]byte{0x01, 0x02, 0x03, 0x04}, 83).EncryptInto(true, true)(dst, src)
+		if !bytes.Equal(expected, actual) {
+			t.Errorf("Size %d: EncryptInto diverged from Encrypt.", size)
+		}
+
+		inPlace := append([]byte(nil), src...)
+		inPlace = NewAESOFB([]byte{0x01, 0x02, 0x03, 0x04}, 83).EncryptInto(true, true)(inPlace, inPlace)
+		if !bytes.Equal(expected, inPlace) {
+			t.Errorf("Size %d: aliased EncryptInto diverged from Encrypt.", size)
+		}
+	}
+}
+
+func TestDecryptInPlaceMatchesDecrypt(t *testing.T) {
+	for _, size := range []int{1, 64, 1456, 1457, 4000} {
+		src := make([]byte, size)
+		for i := range src {
+			src[i] = byte(i * 13)
+		}
+
+		expected := NewAESOFB([]byte{0x0B, 0x60, 0x8B, 0xAE}, 83).Decrypt(true, true)(src)
+
+		NewAESOFB([]byte{0x0B, 0x60, 0x8B, 0xAE}, 83).DecryptInPlace(true, true)(src)
+		if !bytes.Equal(expected, src) {
+			t.Errorf("Size %d: DecryptInPlace diverged from Decrypt.", size)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	if rol(0x81, 1) != 0x03 || rol(0x81, 9) != 0x03 {
+		t.Errorf("rol mismatch")
+	}
+	if ror(0x81, 1) != 0xC0 || ror(0x81, 17) != 0xC0 {
+		t.Errorf("ror mismatch")
+	}
+}
+
+var benchmarkSizes = []struct {
+	name string
+	size int
+}{
+	{"64B", 64},
+	{"1KB", 1024},
+	{"16KB", 16 * 1024},
+}
+
+func BenchmarkEncrypt(b *testing.B) {
+	for _, bs := range benchmarkSizes {
+		b.Run(bs.name, func(b *testing.B) {
+			a := NewAESOFB([]byte{0x01, 0x02, 0x03, 0x04}, 83)
+			encrypt := a.Encrypt(true, true)
+			src := make([]byte, bs.size+4)
+			b.SetBytes(int64(bs.size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = encrypt(src)
+			}
+		})
+	}
+}
+
+func BenchmarkEncryptInto(b *testing.B) {
+	for _, bs := range benchmarkSizes {
+		b.Run(bs.name, func(b *testing.B) {
+			a := NewAESOFB([]byte{0x01, 0x02, 0x03, 0x04}, 83)
+			encrypt := a.EncryptInto(true, true)
+			src := make([]byte, bs.size+4)
+			dst := make([]byte, 0, len(src))
+			b.SetBytes(int64(bs.size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				dst = encrypt(dst, src)
+			}
+		})
+	}
+}
+
+func BenchmarkDecrypt(b *testing.B) {
+	for _, bs := range benchmarkSizes {
+		b.Run(bs.name, func(b *testing.B) {
+			a := NewAESOFB([]byte{0x01, 0x02, 0x03, 0x04}, 83)
+			decrypt := a.Decrypt(true, true)
+			buf := make([]byte, bs.size)
+			b.SetBytes(int64(bs.size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = decrypt(buf)
+			}
+		})
+	}
+}
+
+func BenchmarkDecryptInPlace(b *testing.B) {
+	for _, bs := range benchmarkSizes {
+		b.Run(bs.name, func(b *testing.B) {
+			a := NewAESOFB([]byte{0x01, 0x02, 0x03, 0x04}, 83)
+			decrypt := a.DecryptInPlace(true, true)
+			buf := make([]byte, bs.size)
+			b.SetBytes(int64(bs.size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				decrypt(buf)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package crypto
+
+import (
+	"errors"
+)
+
+// Cipher is implemented by every packet encryption scheme supported by the library. Packets handed to GenerateHeader reserve the first four bytes for the header, bodies handed to EncryptBody and DecryptBody exclude it.
+type Cipher interface {
+	IV() []byte
+	GenerateHeader(packet []byte) error
+	PacketLength(header []byte) int
+	EncryptBody(body []byte)
+	DecryptBody(body []byte)
+	Shuffle()
+}
+
+var _ Cipher = (*AESOFB)(nil)
+var _ Cipher = (*ShandaCipher)(nil)
+var _ Cipher = (*PlaintextCipher)(nil)
+
+// MaxBodySize is the largest body length the two byte length field of a header can carry.
+const MaxBodySize = 0xFFFF
+
+var ErrBodyTooLarge = errors.New("packet body too large for header")
+
+func checkBodySize(packet []byte) error {
+	if len(packet)-encryptHeaderSize > MaxBodySize {
+		return ErrBodyTooLarge
+	}
+	return nil
+}
+
+// EncryptPacket writes the header, encrypts the body of packet in place and rotates the IV. Packets whose body cannot be described by the header are left untouched and the IV is not rotated.
+func EncryptPacket(c Cipher, packet []byte) error {
+	if err := c.GenerateHeader(packet); err != nil {
+		return err
+	}
+	c.EncryptBody(packet[encryptHeaderSize:])
+	c.Shuffle()
+	return nil
+}
+
+// DecryptPacket decrypts a packet body in place and rotates the IV.
+func DecryptPacket(c Cipher, body []byte) {
+	c.DecryptBody(body)
+	c.Shuffle()
+}
+
+func (a *AESOFB) GenerateHeader(packet []byte) error {
+	if err := checkBodySize(packet); err != nil {
+		return err
+	}
+	a.generateHeader(packet)
+	return nil
+}
+
+func (a *AESOFB) PacketLength(header []byte) int {
+	return PacketLength(header)
+}
+
+// EncryptBody applies the GMS pipeline, Shanda followed by AES-OFB.
+func (a *AESOFB) EncryptBody(body []byte) {
+	a.mapleCrypt(body)
+	a.aesCrypt(body)
+}
+
+// DecryptBody reverses EncryptBody.
+func (a *AESOFB) DecryptBody(body []byte) {
+	a.aesCrypt(body)
+	a.mapleDecrypt(body)
+}
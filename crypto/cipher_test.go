@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCipherRoundTrip(t *testing.T) {
+	iv := []byte{0x0B, 0x60, 0x8B, 0xAE}
+	pairs := []struct {
+		name string
+		send Cipher
+		recv Cipher
+	}{
+		{"gms", NewAESOFB(copyIv(iv), 83), NewAESOFB(copyIv(iv), 83)},
+		{"shanda", NewShandaCipher(copyIv(iv), 12), NewShandaCipher(copyIv(iv), 12)},
+		{"plaintext", NewPlaintextCipher(), NewPlaintextCipher()},
+	}
+
+	for _, p := range pairs {
+		t.Run(p.name, func(t *testing.T) {
+			for i, size := range []int{0, 1, 64, 1456, 1457, 3000} {
+				body := make([]byte, size)
+				for j := range body {
+					body[j] = byte(j + i)
+				}
+				packet := append(make([]byte, encryptHeaderSize), body...)
+				if err := EncryptPacket(p.send, packet); err != nil {
+					t.Fatal(err)
+				}
+
+				if p.recv.PacketLength(packet) != size {
+					t.Fatalf("Packet %d: header length %d expected %d.", i, p.recv.PacketLength(packet), size)
+				}
+				DecryptPacket(p.recv, packet[encryptHeaderSize:])
+				if !bytes.Equal(packet[encryptHeaderSize:], body) {
+					t.Fatalf("Packet %d: round trip mismatch.", i)
+				}
+				if !bytes.Equal(p.send.IV(), p.recv.IV()) {
+					t.Fatalf("Packet %d: IVs diverged.", i)
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptPacketMatchesEncrypt(t *testing.T) {
+	packet := append(make([]byte, encryptHeaderSize), []byte("atlas")...)
+	expected := NewAESOFB([]byte{0x01, 0x02, 0x03, 0x04}, 83).Encrypt(true, true)(packet)
+	if err := EncryptPacket(NewAESOFB([]byte{0x01, 0x02, 0x03, 0x04}, 83), packet); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(packet, expected) {
+		t.Errorf("EncryptPacket diverged from Encrypt.")
+	}
+}
+
+func TestEncryptPacketRejectsOversizedBody(t *testing.T) {
+	iv := []byte{0x0B, 0x60, 0x8B, 0xAE}
+	for _, c := range []Cipher{NewAESOFB(copyIv(iv), 83), NewShandaCipher(copyIv(iv), 12), NewPlaintextCipher()} {
+		before := c.IV()
+		packet := make([]byte, encryptHeaderSize+MaxBodySize+1)
+		if err := EncryptPacket(c, packet); !errors.Is(err, ErrBodyTooLarge) {
+			t.Errorf("%T: expected ErrBodyTooLarge, got %v.", c, err)
+		}
+		if !bytes.Equal(c.IV(), before) {
+			t.Errorf("%T: IV rotated for a rejected packet.", c)
+		}
+		if err := EncryptPacket(c, make([]byte, encryptHeaderSize+MaxBodySize)); err != nil {
+			t.Errorf("%T: body of MaxBodySize rejected, %v.", c, err)
+		}
+	}
+}
+
+// FuzzDecrypt feeds arbitrary headers and bodies to every scheme, as a hostile client would. Decoding must stay in range and must not disturb the IV sequence shared with the peer.
+func FuzzDecrypt(f *testing.F) {
+	f.Add([]byte{0x0B, 0x60, 0x8B, 0xAE}, uint16(83), []byte{0x00, 0x00, 0x00, 0x00}, []byte("hello"))
+	f.Add([]byte{0xFF, 0xFF, 0xFF, 0xFF}, uint16(0xFFFF), []byte{0xFF, 0xFF, 0xFF, 0xFF}, make([]byte, 1461))
+	f.Add([]byte{0x00, 0x00, 0x00, 0x00}, uint16(0), []byte{0x12, 0x34, 0x56, 0x78}, []byte{})
+	f.Fuzz(func(t *testing.T, iv []byte, version uint16, header []byte, body []byte) {
+		if len(iv) != 4 || len(header) != encryptHeaderSize || len(body) > 0xFFFF {
+			return
+		}
+		schemes := []func() Cipher{
+			func() Cipher { return NewAESOFB(copyIv(iv), version) },
+			func() Cipher { return NewShandaCipher(copyIv(iv), version) },
+			func() Cipher { return NewPlaintextCipher() },
+		}
+		for i, s := range schemes {
+			recv, send := s(), s()
+			if l := recv.PacketLength(header); l < 0 || l > 0xFFFF {
+				t.Fatalf("Scheme %d decoded length %d.", i, l)
+			}
+
+			garbage := append([]byte(nil), body...)
+			DecryptPacket(recv, garbage)
+			if len(garbage) != len(body) {
+				t.Fatalf("Scheme %d changed the body length.", i)
+			}
+			if len(recv.IV()) != len(iv) && i != 2 {
+				t.Fatalf("Scheme %d IV is %d bytes.", i, len(recv.IV()))
+			}
+
+			// The sender advanced past the same packet, so the next one must still round trip.
+			EncryptPacket(send, append(make([]byte, encryptHeaderSize), body...))
+			packet := append(make([]byte, encryptHeaderSize), 0x01, 0x02, 0x03)
+			EncryptPacket(send, packet)
+			DecryptPacket(recv, packet[encryptHeaderSize:])
+			if !bytes.Equal(packet[encryptHeaderSize:], []byte{0x01, 0x02, 0x03}) {
+				t.Fatalf("Scheme %d lost synchronization after a malformed packet.", i)
+			}
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"sync"
+)
+
+// SendCipher encrypts outbound packets. It owns a private copy of its IV and serializes access, so a single instance may be shared by every goroutine writing to a session.
+type SendCipher struct {
+	mu sync.Mutex
+	a  *AESOFB
+}
+
+// RecvCipher decrypts inbound packets. It owns a private copy of its IV and serializes access, so a single instance may be shared by every goroutine reading from a session.
+type RecvCipher struct {
+	mu sync.Mutex
+	a  *AESOFB
+}
+
+//goland:noinspection GoUnusedExportedFunction
+func NewSendCipher(iv []byte, version uint16, configurators ...Configurator) *SendCipher {
+	return &SendCipher{a: NewAESOFB(copyIv(iv), version, configurators...)}
+}
+
+//goland:noinspection GoUnusedExportedFunction
+func NewRecvCipher(iv []byte, version uint16, configurators ...Configurator) *RecvCipher {
+	return &RecvCipher{a: NewAESOFB(copyIv(iv), version, configurators...)}
+}
+
+// IV returns a snapshot of the IV which will be used for the next packet.
+func (s *SendCipher) IV() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return copyIv(s.a.iv)
+}
+
+func (s *SendCipher) Encrypt(maple bool, aes bool) EncryptFunc {
+	encrypt := s.a.Encrypt(maple, aes)
+	return func(input []byte) []byte {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return encrypt(input)
+	}
+}
+
+func (s *SendCipher) EncryptInto(maple bool, aes bool) func(dst []byte, src []byte) []byte {
+	encrypt := s.a.EncryptInto(maple, aes)
+	return func(dst []byte, src []byte) []byte {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return encrypt(dst, src)
+	}
+}
+
+// IV returns a snapshot of the IV which will be used for the next packet.
+func (r *RecvCipher) IV() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return copyIv(r.a.iv)
+}
+
+func (r *RecvCipher) Decrypt(aes bool, maple bool) DecryptFunc {
+	decrypt := r.a.Decrypt(aes, maple)
+	return func(input []byte) []byte {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return decrypt(input)
+	}
+}
+
+func (r *RecvCipher) DecryptInPlace(aes bool, maple bool) func(buf []byte) {
+	decrypt := r.a.DecryptInPlace(aes, maple)
+	return func(buf []byte) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		decrypt(buf)
+	}
+}
+
+func copyIv(iv []byte) []byte {
+	c := make([]byte, len(iv))
+	copy(c, iv)
+	return c
+}
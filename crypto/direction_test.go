@@ -0,0 +1,94 @@
+package crypto
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestSendCipherConcurrentEncrypt(t *testing.T) {
+	iv := []byte{0x52, 0x30, 0x78, 0x61}
+	send := NewSendCipher(iv, 83)
+	encrypt := send.Encrypt(true, true)
+
+	const workers = 8
+	const perWorker = 64
+
+	results := make(chan []byte, workers*perWorker)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				results <- encrypt(make([]byte, 4+16))
+			}
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	reference := NewAESOFB(copyIv(iv), 83)
+	expected := make(map[string]int)
+	for i := 0; i < workers*perWorker; i++ {
+		expected[string(reference.Encrypt(true, true)(make([]byte, 4+16)))]++
+	}
+
+	for r := range results {
+		if expected[string(r)] == 0 {
+			t.Fatalf("Unexpected ciphertext [% X].", r)
+		}
+		expected[string(r)]--
+	}
+
+	if !bytes.Equal(send.IV(), reference.IV()) {
+		t.Errorf("IV mismatch. got [% X] expected [% X].", send.IV(), reference.IV())
+	}
+}
+
+func TestRecvCipherConcurrentDecrypt(t *testing.T) {
+	iv := []byte{0x0B, 0x60, 0x8B, 0xAE}
+	recv := NewRecvCipher(iv, 83)
+	decrypt := recv.Decrypt(true, true)
+
+	const workers = 8
+	const perWorker = 64
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				_ = decrypt(make([]byte, 32))
+				_ = recv.IV()
+			}
+		}()
+	}
+	wg.Wait()
+
+	reference := NewAESOFB(copyIv(iv), 83)
+	for i := 0; i < workers*perWorker; i++ {
+		reference.Shuffle()
+	}
+
+	if !bytes.Equal(recv.IV(), reference.IV()) {
+		t.Errorf("IV mismatch. got [% X] expected [% X].", recv.IV(), reference.IV())
+	}
+}
+
+func TestCipherOwnsIv(t *testing.T) {
+	iv := []byte{0x01, 0x02, 0x03, 0x04}
+	send := NewSendCipher(iv, 83)
+	recv := NewRecvCipher(iv, 83)
+
+	send.Encrypt(true, true)(make([]byte, 8))
+	recv.Decrypt(true, true)(make([]byte, 4))
+
+	if !bytes.Equal(iv, []byte{0x01, 0x02, 0x03, 0x04}) {
+		t.Errorf("Caller IV was mutated to [% X].", iv)
+	}
+	if !bytes.Equal(send.IV(), recv.IV()) {
+		t.Errorf("Directions diverged. send [% X] recv [% X].", send.IV(), recv.IV())
+	}
+}
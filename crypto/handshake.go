@@ -0,0 +1,57 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var ErrInvalidHandshake = errors.New("invalid handshake")
+
+// Handshake is the unencrypted hello a server sends when a client connects. RecvIv and SendIv are from the server's point of view, so the client encrypts with RecvIv and decrypts with SendIv.
+type Handshake struct {
+	Version uint16
+	Patch   string
+	RecvIv  []byte
+	SendIv  []byte
+	Locale  byte
+}
+
+// Bytes encodes the handshake with its length prefix.
+func (h Handshake) Bytes() []byte {
+	body := make([]byte, 0, 16+len(h.Patch))
+	body = binary.LittleEndian.AppendUint16(body, h.Version)
+	body = binary.LittleEndian.AppendUint16(body, uint16(len(h.Patch)))
+	body = append(body, h.Patch...)
+	body = append(body, h.RecvIv[:4]...)
+	body = append(body, h.SendIv[:4]...)
+	body = append(body, h.Locale)
+	return append(binary.LittleEndian.AppendUint16(nil, uint16(len(body))), body...)
+}
+
+// ReadHandshake decodes the hello written by Handshake.Bytes.
+func ReadHandshake(r io.Reader) (Handshake, error) {
+	var size uint16
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return Handshake{}, err
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return Handshake{}, err
+	}
+	if len(body) < 4 {
+		return Handshake{}, ErrInvalidHandshake
+	}
+
+	h := Handshake{Version: binary.LittleEndian.Uint16(body)}
+	patchLen := int(binary.LittleEndian.Uint16(body[2:]))
+	if len(body) != 4+patchLen+9 {
+		return Handshake{}, ErrInvalidHandshake
+	}
+	h.Patch = string(body[4 : 4+patchLen])
+	rest := body[4+patchLen:]
+	h.RecvIv = append([]byte(nil), rest[0:4]...)
+	h.SendIv = append([]byte(nil), rest[4:8]...)
+	h.Locale = rest[8]
+	return h, nil
+}
@@ -0,0 +1,30 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestHandshakeRoundTrip(t *testing.T) {
+	h := Handshake{Version: 83, Patch: "1", RecvIv: []byte{1, 2, 3, 4}, SendIv: []byte{5, 6, 7, 8}, Locale: 8}
+	actual, err := ReadHandshake(bytes.NewReader(h.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.Version != 83 || actual.Patch != "1" || !bytes.Equal(actual.RecvIv, h.RecvIv) || !bytes.Equal(actual.SendIv, h.SendIv) || actual.Locale != 8 {
+		t.Errorf("Unexpected handshake %+v.", actual)
+	}
+	if h.Bytes()[0] != 0x0E {
+		t.Errorf("Expected GMS hello length 0x0E, got 0x%02X.", h.Bytes()[0])
+	}
+}
+
+func TestReadHandshakeRejectsMalformed(t *testing.T) {
+	h := Handshake{Version: 83, Patch: "1", RecvIv: []byte{1, 2, 3, 4}, SendIv: []byte{5, 6, 7, 8}, Locale: 8}.Bytes()
+	// The patch length claims more bytes than the body holds.
+	h[4] = 0x09
+	if _, err := ReadHandshake(bytes.NewReader(h)); !errors.Is(err, ErrInvalidHandshake) {
+		t.Errorf("Expected ErrInvalidHandshake, got %v.", err)
+	}
+}
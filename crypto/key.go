@@ -0,0 +1,71 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// CompactKey is the 8 dword form in which user keys appear in client binaries.
+type CompactKey [8]uint32
+
+// Expand produces the 32 byte AES user key, each dword stored little endian.
+func (k CompactKey) Expand() []byte {
+	result := make([]byte, len(k)*4)
+	for i, v := range k {
+		binary.LittleEndian.PutUint32(result[i*4:], v)
+	}
+	return result
+}
+
+// Region names a family of client builds sharing user keys. Only GMS ships with a registered key, other regions and private builds register theirs with RegisterKey.
+type Region string
+
+const RegionGMS Region = "GMS"
+
+// DefaultCompactKey is the long-standing GMS user key. It is registered for every GMS version; services running clients with rotated or custom keys register them with RegisterKey.
+var DefaultCompactKey = CompactKey{0x13, 0x08, 0x06, 0xB4, 0x1B, 0x0F, 0x33, 0x52}
+
+var (
+	ErrKeyNotFound    = errors.New("no user key registered for region and version")
+	ErrInvalidKeySize = errors.New("user key must be 16, 24 or 32 bytes")
+)
+
+type keyEntry struct {
+	minVersion uint16
+	maxVersion uint16
+	key        CompactKey
+}
+
+type keyRegistry struct {
+	mu      sync.RWMutex
+	entries map[Region][]keyEntry
+}
+
+var keys = &keyRegistry{
+	entries: map[Region][]keyEntry{
+		RegionGMS: {{minVersion: 0, maxVersion: 0xFFFF, key: DefaultCompactKey}},
+	},
+}
+
+// RegisterKey adds a user key for a region and inclusive version range. Keys registered later take precedence over earlier registrations covering the same version, which allows custom keys to shadow the built-in ones.
+//
+//goland:noinspection GoUnusedExportedFunction
+func RegisterKey(region Region, minVersion uint16, maxVersion uint16, key CompactKey) {
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+	keys.entries[region] = append(keys.entries[region], keyEntry{minVersion: minVersion, maxVersion: maxVersion, key: key})
+}
+
+// LookupKey returns the expanded user key registered for the region and version.
+func LookupKey(region Region, version uint16) ([]byte, error) {
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+	entries := keys.entries[region]
+	for i := len(entries) - 1; i >= 0; i-- {
+		if version >= entries[i].minVersion && version <= entries[i].maxVersion {
+			return entries[i].key.Expand(), nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
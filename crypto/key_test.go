@@ -0,0 +1,87 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCompactKeyExpand(t *testing.T) {
+	expected := []byte{0x13, 0x00, 0x00, 0x00, 0x08, 0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0xB4, 0x00, 0x00, 0x00, 0x1B, 0x00, 0x00, 0x00, 0x0F, 0x00, 0x00, 0x00, 0x33, 0x00, 0x00, 0x00, 0x52, 0x00, 0x00, 0x00}
+	if !bytes.Equal(DefaultCompactKey.Expand(), expected) {
+		t.Errorf("Expanded key mismatch [% X].", DefaultCompactKey.Expand())
+	}
+	if !bytes.Equal(CompactKey{0x04030201}.Expand()[:4], []byte{0x01, 0x02, 0x03, 0x04}) {
+		t.Errorf("Dwords must expand little endian.")
+	}
+}
+
+func TestLookupKey(t *testing.T) {
+	k, err := LookupKey(RegionGMS, 83)
+	if err != nil || !bytes.Equal(k, key) {
+		t.Fatalf("Expected default key for GMS v83.")
+	}
+
+	if _, err = LookupKey(Region("TEST"), 1); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound, got %v.", err)
+	}
+
+	custom := CompactKey{1, 2, 3, 4, 5, 6, 7, 8}
+	RegisterKey(Region("TEST"), 10, 20, custom)
+	if _, err = LookupKey(Region("TEST"), 21); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected version outside range to miss.")
+	}
+	if k, err = LookupKey(Region("TEST"), 15); err != nil || !bytes.Equal(k, custom.Expand()) {
+		t.Fatalf("Expected custom key for registered range.")
+	}
+}
+
+func TestProfileNewCipher(t *testing.T) {
+	custom := CompactKey{0xA, 0xB, 0xC, 0xD, 0xE, 0xF, 0x10, 0x11}
+	RegisterKey(Region("PRIVATE"), 83, 83, custom)
+
+	p := CryptoProfile{Region: Region("PRIVATE"), Version: 83}
+	c, err := p.NewCipher([]byte{0x01, 0x02, 0x03, 0x04}, 83)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet := append(make([]byte, encryptHeaderSize), []byte("atlas")...)
+	expected := NewAESOFB([]byte{0x01, 0x02, 0x03, 0x04}, 83, SetKey(custom.Expand())).Encrypt(true, true)(packet)
+	EncryptPacket(c, packet)
+	if !bytes.Equal(packet, expected) {
+		t.Errorf("Profile cipher did not use the registered key.")
+	}
+
+	if _, err = (CryptoProfile{Region: Region("NONE")}).NewCipher([]byte{0, 0, 0, 0}, 1); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v.", err)
+	}
+	if _, err = (CryptoProfile{Scheme: SchemePlaintext}).NewCipher(nil, 1); err != nil {
+		t.Errorf("Plaintext profile should not need a key, got %v.", err)
+	}
+	if _, err = (CryptoProfile{Scheme: SchemeShanda, Key: custom.Expand()}).NewCipher([]byte{0, 0, 0, 0}, 1); !errors.Is(err, ErrUnusedKey) {
+		t.Errorf("Expected ErrUnusedKey for a keyed Shanda profile, got %v.", err)
+	}
+	if _, err = (CryptoProfile{Scheme: SchemeShanda, IvGenerator: FillIvZeroGenerator}).NewCipher([]byte{0, 0, 0, 0}, 1); !errors.Is(err, ErrUnusedKey) {
+		t.Errorf("Expected ErrUnusedKey for a Shanda profile with an IV generator, got %v.", err)
+	}
+	if _, err = (CryptoProfile{Scheme: Scheme(0xFF)}).NewCipher([]byte{0, 0, 0, 0}, 1); !errors.Is(err, ErrUnknownScheme) {
+		t.Errorf("Expected ErrUnknownScheme, got %v.", err)
+	}
+	if _, err = (CryptoProfile{Key: make([]byte, 20)}).NewCipher([]byte{0, 0, 0, 0}, 1); !errors.Is(err, ErrInvalidKeySize) {
+		t.Errorf("Expected ErrInvalidKeySize, got %v.", err)
+	}
+}
+
+func TestSetProfile(t *testing.T) {
+	custom := CompactKey{0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7, 0x8}
+	a := NewAESOFB([]byte{0x01, 0x02, 0x03, 0x04}, 83, SetProfile(CryptoProfile{Key: custom.Expand(), IvGenerator: FillIvZeroGenerator}))
+	if !bytes.Equal(a.key, custom.Expand()) {
+		t.Errorf("Profile key was not applied.")
+	}
+
+	a = NewAESOFB([]byte{0x01, 0x02, 0x03, 0x04}, 83, SetProfile(CryptoProfile{Region: Region("NONE")}))
+	if !bytes.Equal(a.key, key) {
+		t.Errorf("Unresolvable profile replaced the key.")
+	}
+}
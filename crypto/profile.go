@@ -0,0 +1,89 @@
+package crypto
+
+import (
+	"errors"
+)
+
+type Scheme byte
+
+const (
+	SchemeAESOFB Scheme = iota
+	SchemeShanda
+	SchemePlaintext
+)
+
+var (
+	ErrUnknownScheme = errors.New("unknown cipher scheme")
+	ErrUnusedKey     = errors.New("cipher scheme does not use a user key or IV generator")
+)
+
+// CryptoProfile describes how a client build encrypts traffic. Key, when set, overrides the registry lookup. IvGenerator defaults to DefaultIvGenerator. Key and IvGenerator only apply to SchemeAESOFB.
+type CryptoProfile struct {
+	Region      Region
+	Version     uint16
+	Scheme      Scheme
+	Key         []byte
+	IvGenerator IvGenerator
+}
+
+// ResolveKey returns the explicit key, or the registered key for the region and version. Keys which are not a valid AES key size are rejected.
+func (p CryptoProfile) ResolveKey() ([]byte, error) {
+	k := p.Key
+	if k == nil {
+		var err error
+		if k, err = LookupKey(p.Region, p.Version); err != nil {
+			return nil, err
+		}
+	}
+	if len(k) != 16 && len(k) != 24 && len(k) != 32 {
+		return nil, ErrInvalidKeySize
+	}
+	return k, nil
+}
+
+// Configurators returns the AESOFB configurators which realize the profile.
+func (p CryptoProfile) Configurators() ([]Configurator, error) {
+	k, err := p.ResolveKey()
+	if err != nil {
+		return nil, err
+	}
+	result := []Configurator{SetKey(k)}
+	if p.IvGenerator != nil {
+		result = append(result, SetIvGenerator(p.IvGenerator))
+	}
+	return result, nil
+}
+
+// NewCipher builds a Cipher for the profile. version is passed through to the header scheme, callers supply the inverted version for the server to client direction. Profiles for keyless schemes which set Key or IvGenerator are rejected with ErrUnusedKey rather than silently ignored.
+func (p CryptoProfile) NewCipher(iv []byte, version uint16) (Cipher, error) {
+	if p.Scheme == SchemePlaintext || p.Scheme == SchemeShanda {
+		if p.Key != nil || p.IvGenerator != nil {
+			return nil, ErrUnusedKey
+		}
+		if p.Scheme == SchemeShanda {
+			return NewShandaCipher(iv, version), nil
+		}
+		return NewPlaintextCipher(), nil
+	}
+	if p.Scheme != SchemeAESOFB {
+		return nil, ErrUnknownScheme
+	}
+
+	cs, err := p.Configurators()
+	if err != nil {
+		return nil, err
+	}
+	return NewAESOFB(iv, version, cs...), nil
+}
+
+// SetProfile applies the key and IV generator of a profile. A profile whose key cannot be resolved leaves the key unchanged, so check it with ResolveKey first. Servers using socket.SetCryptoProfile have the profile checked by Start.
+func SetProfile(p CryptoProfile) Configurator {
+	return func(a *AESOFB) {
+		if k, err := p.ResolveKey(); err == nil {
+			a.key = k
+		}
+		if p.IvGenerator != nil {
+			a.ivGenerator = p.IvGenerator
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package crypto
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+type versionCase struct {
+	name       string
+	version    uint16
+	fillIvZero bool
+}
+
+var versionCases = []versionCase{
+	{"gms-v12", 12, true},
+	{"gms-v62", 62, false},
+	{"gms-v83", 83, false},
+	{"gms-v95", 95, false},
+}
+
+func (g versionCase) configurators() []Configurator {
+	if g.fillIvZero {
+		return []Configurator{SetIvGenerator(FillIvZeroGenerator)}
+	}
+	return nil
+}
+
+func TestGenerateHeader(t *testing.T) {
+	a := NewAESOFB([]byte{0x0B, 0x60, 0x8B, 0xAE}, 83)
+	header := make([]byte, encryptHeaderSize+2)
+	a.generateHeader(header)
+
+	// iv[2:4] = 0x8BAE, version 83 byte swapped = 0x5300, 0x8BAE ^ 0x5300 = 0xD8AE.
+	expected := []byte{0xD8, 0xAE, 0xDA, 0xAE}
+	if !bytes.Equal(header[:encryptHeaderSize], expected) {
+		t.Errorf("Header mismatch. got [% X] expected [% X].", header[:encryptHeaderSize], expected)
+	}
+	if PacketLength(header) != 2 {
+		t.Errorf("PacketLength got %d expected 2.", PacketLength(header))
+	}
+}
+
+func TestShuffleDeterministic(t *testing.T) {
+	a := NewAESOFB([]byte{0x00, 0x00, 0x00, 0x00}, 83)
+	b := NewAESOFB([]byte{0x00, 0x00, 0x00, 0x00}, 95)
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		a.Shuffle()
+		b.Shuffle()
+		if !bytes.Equal(a.IV(), b.IV()) {
+			t.Fatalf("Shuffle depends on more than the IV.")
+		}
+		seen[string(a.IV())] = true
+	}
+	if len(seen) < 990 {
+		t.Errorf("Shuffle cycled early, only %d distinct IVs.", len(seen))
+	}
+}
+
+func TestRoundTripAcrossIvEvolutions(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, gc := range versionCases {
+		t.Run(gc.name, func(t *testing.T) {
+			iv := make([]byte, 4)
+			r.Read(iv)
+			send := NewAESOFB(copyIv(iv), gc.version, gc.configurators()...)
+			recv := NewAESOFB(copyIv(iv), gc.version, gc.configurators()...)
+			for i := 0; i < 500; i++ {
+				p := make([]byte, r.Intn(3000))
+				r.Read(p)
+				c := send.Encrypt(true, true)(append(make([]byte, encryptHeaderSize), p...))
+				if PacketLength(c) != len(p) {
+					t.Fatalf("Packet %d: header length mismatch.", i)
+				}
+				if actual := recv.Decrypt(true, true)(c[encryptHeaderSize:]); !bytes.Equal(actual, p) {
+					t.Fatalf("Packet %d: round trip mismatch.", i)
+				}
+			}
+		})
+	}
+}
+
+func TestMapleCryptRoundTrip(t *testing.T) {
+	a := NewAESOFB([]byte{0x01, 0x02, 0x03, 0x04}, 83)
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 200; i++ {
+		p := make([]byte, r.Intn(512))
+		r.Read(p)
+		w := append([]byte(nil), p...)
+		a.mapleCrypt(w)
+		a.mapleDecrypt(w)
+		if !bytes.Equal(w, p) {
+			t.Fatalf("Iteration %d: maple round trip mismatch.", i)
+		}
+	}
+}
+
+func FuzzPacketLength(f *testing.F) {
+	f.Add([]byte{0x0B, 0x60, 0x8B, 0xAE}, uint16(83), uint16(0))
+	f.Add([]byte{0xFF, 0xFF, 0xFF, 0xFF}, uint16(12), uint16(0xFFFF))
+	f.Fuzz(func(t *testing.T, iv []byte, version uint16, length uint16) {
+		if len(iv) != 4 {
+			return
+		}
+		a := NewAESOFB(copyIv(iv), version)
+		packet := make([]byte, encryptHeaderSize+int(length))
+		a.generateHeader(packet)
+		if PacketLength(packet) != int(length) {
+			t.Fatalf("Header for length %d decoded as %d.", length, PacketLength(packet))
+		}
+	})
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add([]byte{0x0B, 0x60, 0x8B, 0xAE}, uint16(83), []byte("hello"))
+	f.Add([]byte{0x46, 0x72, 0x7A, 0xB7}, uint16(12), make([]byte, 1460))
+	f.Fuzz(func(t *testing.T, iv []byte, version uint16, body []byte) {
+		if len(iv) != 4 || len(body) > 0xFFFF {
+			return
+		}
+		send := NewAESOFB(copyIv(iv), version)
+		recv := NewAESOFB(copyIv(iv), version)
+		c := send.Encrypt(true, true)(append(make([]byte, encryptHeaderSize), body...))
+		if PacketLength(c) != len(body) {
+			t.Fatalf("Header length mismatch.")
+		}
+		if actual := recv.Decrypt(true, true)(c[encryptHeaderSize:]); !bytes.Equal(actual, body) {
+			t.Fatalf("Round trip mismatch.")
+		}
+	})
+}
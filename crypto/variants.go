@@ -0,0 +1,78 @@
+package crypto
+
+// ShandaCipher is used by early clients which predate AES. Bodies are only run through the Shanda transform, headers and IV rotation match AESOFB. No user key is involved.
+type ShandaCipher struct {
+	iv      []byte
+	version uint16
+}
+
+//goland:noinspection GoUnusedExportedFunction
+func NewShandaCipher(iv []byte, version uint16) *ShandaCipher {
+	return &ShandaCipher{iv: iv, version: swapVersion(version)}
+}
+
+func (s *ShandaCipher) IV() []byte {
+	return s.iv
+}
+
+func (s *ShandaCipher) GenerateHeader(packet []byte) error {
+	if err := checkBodySize(packet); err != nil {
+		return err
+	}
+	writeHeader(packet, s.iv, s.version)
+	return nil
+}
+
+func (s *ShandaCipher) PacketLength(header []byte) int {
+	return PacketLength(header)
+}
+
+func (s *ShandaCipher) EncryptBody(body []byte) {
+	shandaEncrypt(body)
+}
+
+func (s *ShandaCipher) DecryptBody(body []byte) {
+	shandaDecrypt(body)
+}
+
+func (s *ShandaCipher) Shuffle() {
+	shuffleIv(s.iv)
+}
+
+// PlaintextCipher performs no encryption. The header carries the body length in its upper two bytes so PacketLength still applies, bodies longer than MaxBodySize are rejected. Intended for local testing only.
+type PlaintextCipher struct {
+}
+
+//goland:noinspection GoUnusedExportedFunction
+func NewPlaintextCipher() *PlaintextCipher {
+	return &PlaintextCipher{}
+}
+
+func (p *PlaintextCipher) IV() []byte {
+	return []byte{0, 0, 0, 0}
+}
+
+func (p *PlaintextCipher) GenerateHeader(packet []byte) error {
+	if err := checkBodySize(packet); err != nil {
+		return err
+	}
+	length := len(packet) - encryptHeaderSize
+	packet[0] = 0
+	packet[1] = 0
+	packet[2] = byte(length)
+	packet[3] = byte(length >> 8)
+	return nil
+}
+
+func (p *PlaintextCipher) PacketLength(header []byte) int {
+	return PacketLength(header)
+}
+
+func (p *PlaintextCipher) EncryptBody(_ []byte) {
+}
+
+func (p *PlaintextCipher) DecryptBody(_ []byte) {
+}
+
+func (p *PlaintextCipher) Shuffle() {
+}
@@ -0,0 +1,106 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"net"
+)
+
+type DisconnectCategory byte
+
+const (
+	// DisconnectClientClosed the client closed the connection.
+	DisconnectClientClosed DisconnectCategory = iota
+	// DisconnectReadError reading from the connection failed.
+	DisconnectReadError
+	// DisconnectShutdown the server is shutting down.
+	DisconnectShutdown
+	// DisconnectKicked the server closed the session on request.
+	DisconnectKicked
+	// DisconnectProtocolViolation the client sent data which could not be processed.
+	DisconnectProtocolViolation
+	// DisconnectIdleTimeout nothing was received within the idle timeout.
+	DisconnectIdleTimeout
+	// DisconnectPingTimeout the client stopped answering pings.
+	DisconnectPingTimeout
+	// DisconnectRateLimited the client exceeded a rate limit with the disconnect policy.
+	DisconnectRateLimited
+	// DisconnectSlowConsumer the client did not read outbound packets quickly enough.
+	DisconnectSlowConsumer
+	// DisconnectWriteError writing to the connection failed.
+	DisconnectWriteError
+	// DisconnectHandlerPanic a handler panicked and the panic handler recovered it.
+	DisconnectHandlerPanic
+)
+
+func (c DisconnectCategory) String() string {
+	switch c {
+	case DisconnectClientClosed:
+		return "client closed"
+	case DisconnectReadError:
+		return "read error"
+	case DisconnectShutdown:
+		return "shutdown"
+	case DisconnectKicked:
+		return "kicked"
+	case DisconnectProtocolViolation:
+		return "protocol violation"
+	case DisconnectIdleTimeout:
+		return "idle timeout"
+	case DisconnectPingTimeout:
+		return "ping timeout"
+	case DisconnectRateLimited:
+		return "rate limited"
+	case DisconnectSlowConsumer:
+		return "slow consumer"
+	case DisconnectWriteError:
+		return "write error"
+	case DisconnectHandlerPanic:
+		return "handler panic"
+	}
+	return fmt.Sprintf("unknown (%d)", c)
+}
+
+// DisconnectReason explains why a session ended. Err holds the underlying error, if any. It implements error so it can be used as a context cancellation cause.
+type DisconnectReason struct {
+	Category DisconnectCategory
+	Err      error
+}
+
+func (r DisconnectReason) Error() string {
+	if r.Err == nil {
+		return r.Category.String()
+	}
+	return fmt.Sprintf("%s: %s", r.Category, r.Err)
+}
+
+func (r DisconnectReason) Unwrap() error {
+	return r.Err
+}
+
+// ReasonDestroyer is invoked exactly once per session with the reason it ended.
+type ReasonDestroyer func(sessionId uuid.UUID, reason DisconnectReason)
+
+func adaptDestroyer(d Destroyer) ReasonDestroyer {
+	return func(sessionId uuid.UUID, _ DisconnectReason) {
+		d(sessionId)
+	}
+}
+
+// disconnectReason classifies the read error which ended a session, preferring the cause the session context was cancelled with.
+func disconnectReason(serverCtx context.Context, sessionCtx context.Context, err error) DisconnectReason {
+	var reason DisconnectReason
+	if errors.As(context.Cause(sessionCtx), &reason) {
+		return reason
+	}
+	if serverCtx.Err() != nil {
+		return DisconnectReason{Category: DisconnectShutdown}
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return DisconnectReason{Category: DisconnectClientClosed}
+	}
+	return DisconnectReason{Category: DisconnectReadError, Err: err}
+}
@@ -0,0 +1,36 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestDisconnectReason(t *testing.T) {
+	server, stop := context.WithCancel(context.Background())
+	defer stop()
+	session, cancel := context.WithCancelCause(server)
+	defer cancel(nil)
+
+	if r := disconnectReason(server, session, io.EOF); r.Category != DisconnectClientClosed {
+		t.Errorf("EOF: expected client closed, got %s.", r)
+	}
+
+	reset := errors.New("connection reset by peer")
+	if r := disconnectReason(server, session, reset); r.Category != DisconnectReadError || !errors.Is(r, reset) {
+		t.Errorf("Reset: expected read error wrapping cause, got %s.", r)
+	}
+
+	kicked, kick := context.WithCancelCause(server)
+	kick(DisconnectReason{Category: DisconnectKicked})
+	if r := disconnectReason(server, kicked, net.ErrClosed); r.Category != DisconnectKicked {
+		t.Errorf("Kick: expected kicked, got %s.", r)
+	}
+
+	stop()
+	if r := disconnectReason(server, session, net.ErrClosed); r.Category != DisconnectShutdown {
+		t.Errorf("Shutdown: expected shutdown, got %s.", r)
+	}
+}
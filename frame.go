@@ -0,0 +1,60 @@
+package socket
+
+import (
+	"errors"
+	"io"
+)
+
+// DefaultMaxPacketSize is the largest body a header can describe.
+const DefaultMaxPacketSize = 0xFFFF
+
+var ErrPacketTooLarge = errors.New("packet exceeds maximum size")
+
+// frameReader assembles whole headers and bodies from a stream which may deliver them across several reads. Partial progress survives read errors such as deadline timeouts, so the caller may poll.
+type frameReader struct {
+	r   io.Reader
+	buf []byte
+	n   int
+}
+
+func newFrameReader(r io.Reader, size int) *frameReader {
+	f := &frameReader{r: r}
+	f.reset(size)
+	return f
+}
+
+// reset begins a new frame of size bytes.
+func (f *frameReader) reset(size int) {
+	f.buf = make([]byte, size)
+	f.n = 0
+}
+
+// fill reads until the frame is complete, returning the bytes read by this call.
+func (f *frameReader) fill() (int, error) {
+	read := 0
+	for f.n < len(f.buf) {
+		n, err := f.r.Read(f.buf[f.n:])
+		f.n += n
+		read += n
+		if err != nil {
+			if errors.Is(err, io.EOF) && f.n == len(f.buf) {
+				return read, nil
+			}
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+// frame is the completed frame.
+func (f *frameReader) frame() []byte {
+	return f.buf
+}
+
+// bodySize validates the length decoded from a header.
+func bodySize(length int, max int) (int, error) {
+	if length < 0 || length > max {
+		return 0, ErrPacketTooLarge
+	}
+	return length, nil
+}
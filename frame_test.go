@@ -0,0 +1,109 @@
+package socket
+
+import (
+	"bytes"
+	"errors"
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"io"
+	"os"
+	"testing"
+)
+
+// chunkedReader returns at most one chunk size per read, alternating with deadline timeouts when stall is set.
+type chunkedReader struct {
+	data   []byte
+	chunks []byte
+	stall  bool
+	calls  int
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	c.calls++
+	if c.stall && c.calls%2 == 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+	if len(c.data) == 0 {
+		return 0, io.EOF
+	}
+	size := 1
+	if len(c.chunks) > 0 {
+		size = int(c.chunks[0]) + 1
+		c.chunks = append(c.chunks[1:], c.chunks[0])
+	}
+	n := copy(p, c.data[:min(size, len(c.data))])
+	c.data = c.data[n:]
+	return n, nil
+}
+
+// readFrames drives a frameReader the way the session read loop does, returning the bodies read.
+func readFrames(r io.Reader, max int) ([][]byte, error) {
+	var bodies [][]byte
+	frames := newFrameReader(r, 4)
+	header := true
+	for {
+		if _, err := frames.fill(); err != nil {
+			if os.IsTimeout(err) {
+				continue
+			}
+			return bodies, err
+		}
+		if header {
+			size, err := bodySize(crypto.PacketLength(frames.frame()), max)
+			if err != nil {
+				return bodies, err
+			}
+			frames.reset(size)
+		} else {
+			bodies = append(bodies, frames.frame())
+			frames.reset(4)
+		}
+		header = !header
+	}
+}
+
+func frame(body []byte) []byte {
+	return append([]byte{0x00, 0x00, byte(len(body)), byte(len(body) >> 8)}, body...)
+}
+
+func TestFrameReaderReassemblesPartialReads(t *testing.T) {
+	stream := append(frame([]byte("hello")), frame(bytes.Repeat([]byte{0xAB}, 3000))...)
+	stream = append(stream, frame(nil)...)
+	stream = append(stream, frame([]byte{0x01})...)
+
+	bodies, err := readFrames(&chunkedReader{data: stream, chunks: []byte{0, 2, 6, 200}, stall: true}, DefaultMaxPacketSize)
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("Expected EOF, got [%v].", err)
+	}
+	if len(bodies) != 4 || string(bodies[0]) != "hello" || len(bodies[1]) != 3000 || len(bodies[2]) != 0 || bodies[3][0] != 0x01 {
+		t.Fatalf("Unexpected bodies [%d].", len(bodies))
+	}
+}
+
+func TestFrameReaderRejectsOversizedPacket(t *testing.T) {
+	_, err := readFrames(&chunkedReader{data: frame(make([]byte, 100))}, 64)
+	if !errors.Is(err, ErrPacketTooLarge) {
+		t.Fatalf("Expected ErrPacketTooLarge, got [%v].", err)
+	}
+}
+
+func FuzzFrameReader(f *testing.F) {
+	f.Add(frame([]byte("hello")), []byte{0, 3}, uint16(DefaultMaxPacketSize))
+	f.Add(append(frame(nil), 0xFF, 0xFF, 0x00, 0x00), []byte{255}, uint16(16))
+	f.Add([]byte{0x01, 0x02}, []byte{}, uint16(0))
+	f.Fuzz(func(t *testing.T, stream []byte, chunks []byte, max uint16) {
+		bodies, err := readFrames(&chunkedReader{data: append([]byte(nil), stream...), chunks: chunks, stall: len(chunks)%2 == 1}, int(max))
+		if err == nil {
+			t.Fatalf("Reader ended without an error.")
+		}
+		total := 0
+		for _, b := range bodies {
+			if len(b) > int(max) {
+				t.Fatalf("Body of [%d] bytes exceeds maximum [%d].", len(b), max)
+			}
+			total += 4 + len(b)
+		}
+		if total > len(stream) {
+			t.Fatalf("Read [%d] bytes from a stream of [%d].", total, len(stream))
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"github.com/Chronicle20/atlas-socket/request"
+	"github.com/google/uuid"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveContextHandler runs a session over a pipe and writes a single packet for op 0x0001. The client end is returned so tests can disconnect it.
+func serveContextHandler(t *testing.T, h request.ContextHandler, configurators ...Configurator) net.Conn {
+	s := New(append([]Configurator{SetLogger(testLogger()), SetReadWriter(ShortReadWriter{}),
+		SetContextHandlers(func() map[uint16]request.ContextHandler {
+			return map[uint16]request.ContextHandler{0x0001: h}
+		})}, configurators...)...)
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = s.Shutdown(context.Background())
+	})
+	go func() {
+		_ = s.ServeConn(server)
+	}()
+	if _, err := client.Write([]byte{0x00, 0x00, 0x02, 0x00, 0x01, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestHandlerContextCancelledOnDisconnect(t *testing.T) {
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	client := serveContextHandler(t, func(ctx context.Context, _ uuid.UUID, _ request.Reader) {
+		close(started)
+		select {
+		case <-ctx.Done():
+			done <- ctx.Err()
+		case <-time.After(time.Second):
+			done <- nil
+		}
+	})
+
+	<-started
+	_ = client.Close()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the handler context to be cancelled, got %v.", err)
+	}
+}
+
+func TestHandlerContextDeadline(t *testing.T) {
+	done := make(chan error, 1)
+	serveContextHandler(t, func(ctx context.Context, _ uuid.UUID, _ request.Reader) {
+		if _, ok := ctx.Deadline(); !ok {
+			done <- errors.New("no deadline")
+			return
+		}
+		<-ctx.Done()
+		done <- ctx.Err()
+	}, SetHandlerTimeout(20*time.Millisecond))
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected DeadlineExceeded, got %v.", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Handler context did not expire.")
+	}
+}
+
+func TestHandlerContextCarriesMetadata(t *testing.T) {
+	type result struct {
+		id uuid.UUID
+		m  request.Metadata
+		ok bool
+	}
+	done := make(chan result, 1)
+	serveContextHandler(t, func(ctx context.Context, id uuid.UUID, _ request.Reader) {
+		m, ok := request.MetadataFromContext(ctx)
+		done <- result{id, m, ok}
+	})
+
+	r := <-done
+	if !r.ok {
+		t.Fatalf("Handler context carries no metadata.")
+	}
+	if r.m.SessionId != r.id || r.m.RemoteAddr == nil || r.m.ConnectedAt.IsZero() {
+		t.Errorf("Unexpected metadata %+v.", r.m)
+	}
+}
+
+// SetHandlers replaces the handler table and SetContextHandlers adds to it, replacing handlers for the same op.
+func TestSetHandlersReplacesAndContextHandlersMerge(t *testing.T) {
+	var called []string
+	legacy := func(name string) request.Handler {
+		return func(_ uuid.UUID, _ request.Reader) {
+			called = append(called, name)
+		}
+	}
+	c := newConfig()
+	for _, configurator := range []Configurator{
+		SetHandlers(func() map[uint16]request.Handler {
+			return map[uint16]request.Handler{0x0001: legacy("a1"), 0x0002: legacy("a2")}
+		}),
+		SetHandlers(func() map[uint16]request.Handler {
+			return map[uint16]request.Handler{0x0002: legacy("b2"), 0x0003: legacy("b3")}
+		}),
+		SetContextHandlers(func() map[uint16]request.ContextHandler {
+			return map[uint16]request.ContextHandler{
+				0x0003: func(_ context.Context, _ uuid.UUID, _ request.Reader) {
+					called = append(called, "c3")
+				},
+				0x0004: func(_ context.Context, _ uuid.UUID, _ request.Reader) {
+					called = append(called, "c4")
+				},
+			}
+		}),
+	} {
+		configurator(c)
+	}
+
+	if _, ok := c.handlers[0x0001]; ok {
+		t.Errorf("Expected the second SetHandlers to drop op 0x0001.")
+	}
+	for _, op := range []uint16{0x0002, 0x0003, 0x0004} {
+		c.handlers[op](context.Background(), uuid.Nil, request.Reader{})
+	}
+	if len(c.handlers) != 3 || len(called) != 3 || called[0] != "b2" || called[1] != "c3" || called[2] != "c4" {
+		t.Errorf("Unexpected dispatch %v.", called)
+	}
+}
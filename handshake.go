@@ -0,0 +1,66 @@
+package socket
+
+import (
+	"crypto/rand"
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"github.com/google/uuid"
+	"net"
+	"time"
+)
+
+// validate checks the parts of a listener configuration which would otherwise fail every session.
+func (c *config) validate() error {
+	if err := c.validateRateLimits(); err != nil {
+		return err
+	}
+	return c.validateProfile()
+}
+
+// validateProfile checks that ciphers can be built for the crypto profile of a listener, so a bad key or scheme fails Start rather than every session.
+func (c *config) validateProfile() error {
+	if c.profile == nil {
+		return nil
+	}
+	_, err := c.profile.NewCipher(make([]byte, 4), c.profile.Version)
+	return err
+}
+
+// profileCrypto performs the hello handshake for a listener with a crypto profile. It returns the decryptor and encryptor of the session, built from the profile with the IVs sent to the client.
+func profileCrypto(config *config, conn net.Conn) (MessageDecryptor, MessageEncryptor, error) {
+	p := *config.profile
+	recvIv := make([]byte, 4)
+	sendIv := make([]byte, 4)
+	_, _ = rand.Read(recvIv)
+	_, _ = rand.Read(sendIv)
+
+	recv, err := p.NewCipher(recvIv, p.Version)
+	if err != nil {
+		return nil, nil, err
+	}
+	send, err := p.NewCipher(sendIv, 0xFFFF-p.Version)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h := crypto.Handshake{Version: p.Version, Patch: config.patch, RecvIv: recvIv, SendIv: sendIv, Locale: config.locale}
+	_ = conn.SetWriteDeadline(time.Now().Add(config.outbound.WriteTimeout))
+	_, err = conn.Write(h.Bytes())
+	_ = conn.SetWriteDeadline(time.Time{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The read loop decrypts on a single goroutine and the outbound queue encrypts under its lock, so neither cipher needs locking of its own.
+	decryptor := func(_ uuid.UUID, message []byte) []byte {
+		crypto.DecryptPacket(recv, message)
+		return message
+	}
+	encryptor := func(_ uuid.UUID, packet []byte) []byte {
+		data := append(make([]byte, 4, 4+len(packet)), packet...)
+		if crypto.EncryptPacket(send, data) != nil {
+			return nil
+		}
+		return data
+	}
+	return decryptor, encryptor, nil
+}
@@ -0,0 +1,85 @@
+package socket
+
+import (
+	"context"
+	"github.com/Chronicle20/atlas-socket/response"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Pinger writes a ping packet to the session.
+type Pinger func(sessionId uuid.UUID) error
+
+// PingConfig enables server initiated pings. A ping is sent every Interval, and the session is closed once MaxMissed consecutive pings go unanswered by a packet with PongOp. Without a Ping, a packet holding only PingOp is queued through Session.Write, so it is encrypted by the crypto profile or MessageEncryptor like any other packet.
+type PingConfig struct {
+	Interval  time.Duration
+	PingOp    uint16
+	PongOp    uint16
+	MaxMissed int
+	Ping      Pinger
+}
+
+type pingMonitor struct {
+	awaiting atomic.Bool
+	missed   atomic.Int32
+}
+
+func (m *pingMonitor) pong() {
+	m.awaiting.Store(false)
+	m.missed.Store(0)
+}
+
+// writePing is the default Pinger, queueing a packet holding only the ping op.
+func writePing(l logrus.FieldLogger, rw OpWriter, op uint16, out *outboundQueue) Pinger {
+	return func(_ uuid.UUID) error {
+		w := response.NewWriter(l)
+		rw.Write(op)(w)
+		return out.enqueue(w.Bytes(), false, nil)
+	}
+}
+
+// runPing sends pings until the session ends, cancelling the session when the client stops answering.
+func runPing(l logrus.FieldLogger, ctx context.Context, cancel context.CancelCauseFunc, cfg PingConfig, sessionId uuid.UUID, m *pingMonitor) {
+	t := time.NewTicker(cfg.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		if m.awaiting.Load() {
+			if missed := m.missed.Add(1); int(missed) >= cfg.MaxMissed {
+				l.Infof("Closing session after [%d] missed pongs.", missed)
+				cancel(DisconnectReason{Category: DisconnectPingTimeout})
+				return
+			}
+		}
+
+		m.awaiting.Store(true)
+		if err := cfg.Ping(sessionId); err != nil {
+			l.WithError(err).Warnf("Unable to ping session.")
+			cancel(DisconnectReason{Category: DisconnectPingTimeout, Err: err})
+			return
+		}
+	}
+}
+
+// applyKeepAlive configures TCP keepalive on the accepted socket. Non TCP connections are left untouched.
+func applyKeepAlive(l logrus.FieldLogger, conn net.Conn, cfg *net.KeepAliveConfig) {
+	if cfg == nil {
+		return
+	}
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if err := tc.SetKeepAliveConfig(*cfg); err != nil {
+		l.WithError(err).Warnf("Unable to configure keepalive for [%s].", conn.RemoteAddr())
+	}
+}
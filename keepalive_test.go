@@ -0,0 +1,102 @@
+package socket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testLogger() logrus.FieldLogger {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	return l
+}
+
+func TestRunPingClosesAfterMissedPongs(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	var pings atomic.Int32
+	cfg := PingConfig{Interval: 5 * time.Millisecond, MaxMissed: 2, Ping: func(_ uuid.UUID) error {
+		pings.Add(1)
+		return nil
+	}}
+
+	done := make(chan struct{})
+	go func() {
+		runPing(testLogger(), ctx, cancel, cfg, uuid.New(), &pingMonitor{})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected session to be closed.")
+	}
+	var reason DisconnectReason
+	if !errors.As(context.Cause(ctx), &reason) || reason.Category != DisconnectPingTimeout {
+		t.Errorf("Expected session to be cancelled with a ping timeout, got %v.", context.Cause(ctx))
+	}
+	if pings.Load() != 2 {
+		t.Errorf("Expected 2 pings before closing, got %d.", pings.Load())
+	}
+}
+
+func TestRunPingKeepsAnsweredSessions(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	m := &pingMonitor{}
+	var pings atomic.Int32
+	cfg := PingConfig{Interval: 2 * time.Millisecond, MaxMissed: 1, Ping: func(_ uuid.UUID) error {
+		pings.Add(1)
+		m.pong()
+		return nil
+	}}
+	go runPing(testLogger(), ctx, cancel, cfg, uuid.New(), m)
+
+	for pings.Load() < 10 {
+		if ctx.Err() != nil {
+			t.Fatalf("Session closed despite pongs.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDefaultPingerWritesThroughSessionCrypto(t *testing.T) {
+	s := New(SetLogger(testLogger()), SetReadWriter(ShortReadWriter{}),
+		SetCryptoProfile(crypto.CryptoProfile{Region: crypto.RegionGMS, Version: 83}),
+		SetPing(PingConfig{Interval: 10 * time.Millisecond, PingOp: 0x0011, PongOp: 0x0018, MaxMissed: 5}))
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		_ = s.ServeConn(server)
+	}()
+	defer s.Shutdown(context.Background())
+
+	h, err := crypto.ReadHandshake(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv := crypto.NewAESOFB(h.SendIv, 0xFFFF-h.Version)
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(client, header); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, recv.PacketLength(header))
+	if _, err = io.ReadFull(client, body); err != nil {
+		t.Fatal(err)
+	}
+	crypto.DecryptPacket(recv, body)
+	if !bytes.Equal(body, []byte{0x11, 0x00}) {
+		t.Errorf("Unexpected ping [% X].", body)
+	}
+}
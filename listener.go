@@ -0,0 +1,56 @@
+package socket
+
+import (
+	"maps"
+	"net"
+	"slices"
+)
+
+type listener struct {
+	config *config
+	lis    net.Listener
+}
+
+// AddListener adds a named listener. It inherits the server configuration, with the configurators applied on top, so address, handlers, op reader, op names, crypto profile and session callbacks can differ per listener. The session registry, metrics, tracer, rate limiter and admission state stay shared. Once a listener is added the server no longer listens with its own address. It fails with ErrServerStarted once the server was started.
+func (s *Server) AddListener(name string, configurators ...Configurator) error {
+	c := s.config.clone()
+	c.name = name
+	c.l = c.l.WithField("listener", name)
+	for _, configurator := range configurators {
+		configurator(c)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return ErrServerStarted
+	}
+	if s.closed {
+		return ErrServerClosed
+	}
+	s.listeners = append(s.listeners, &listener{config: c})
+	return nil
+}
+
+// ListenerAddr is the address a named listener is bound to, or nil when it is unknown or not started.
+func (s *Server) ListenerAddr(name string) net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, li := range s.listeners {
+		if li.config.name == name && li.lis != nil {
+			return li.lis.Addr()
+		}
+	}
+	return nil
+}
+
+// clone copies the configuration for a listener. Maps are copied so per listener configurators do not leak into the server, and a supplied net.Listener is not inherited. Pointer fields holding shared state are intentionally retained.
+func (c *config) clone() *config {
+	n := *c
+	n.listener = nil
+	n.handlers = maps.Clone(c.handlers)
+	n.opNames = maps.Clone(c.opNames)
+	n.opLimits = maps.Clone(c.opLimits)
+	n.trustedProxies = slices.Clone(c.trustedProxies)
+	return &n
+}
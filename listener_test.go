@@ -0,0 +1,189 @@
+package socket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"github.com/Chronicle20/atlas-socket/request"
+	"github.com/google/uuid"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerMultipleListeners(t *testing.T) {
+	s := New(SetLogger(testLogger()), SetIpAddress("127.0.0.1"), SetReadWriter(ShortReadWriter{}))
+	if err := s.AddListener("login", SetPort(0), SetCryptoProfile(crypto.CryptoProfile{Region: crypto.RegionGMS, Version: 83})); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddListener("channel", SetPort(0), SetOpNames(map[uint16]string{0x01: "PING"})); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = s.Shutdown(context.Background())
+	}()
+
+	if s.ListenerAddr("login") == nil || s.ListenerAddr("channel") == nil {
+		t.Fatalf("Expected both listeners to be bound.")
+	}
+	if s.ListenerAddr("login").String() == s.ListenerAddr("channel").String() {
+		t.Fatalf("Expected distinct addresses.")
+	}
+	if len(s.config.opNames) != 0 {
+		t.Errorf("Listener configuration leaked into the server.")
+	}
+
+	for _, name := range []string{"login", "channel"} {
+		conn, err := net.Dial("tcp", s.ListenerAddr(name).String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(s.Sessions()) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected both sessions in the shared registry.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	byListener := make(map[string]*Session)
+	for _, ses := range s.Sessions() {
+		byListener[ses.Listener()] = ses
+	}
+	if p, ok := byListener["login"].CryptoProfile(); !ok || p.Version != 83 {
+		t.Errorf("Expected login session to carry the crypto profile.")
+	}
+	if _, ok := byListener["channel"].CryptoProfile(); ok {
+		t.Errorf("Expected channel session without a crypto profile.")
+	}
+	if s.Stats().TotalConnections != 2 {
+		t.Errorf("Expected shared stats, got %+v.", s.Stats())
+	}
+}
+
+func TestCryptoProfileOwnsSessionCrypto(t *testing.T) {
+	type inbound struct {
+		id   uuid.UUID
+		body []byte
+	}
+	received := make(chan inbound, 1)
+	s := New(SetLogger(testLogger()), SetReadWriter(ShortReadWriter{}), SetHandshake("2", 9),
+		SetCryptoProfile(crypto.CryptoProfile{Region: crypto.RegionGMS, Version: 83}),
+		SetMessageDecryptor(func(_ uuid.UUID, _ []byte) []byte {
+			t.Errorf("Configured decryptor used despite the crypto profile.")
+			return nil
+		}),
+		SetHandlers(func() map[uint16]request.Handler {
+			return map[uint16]request.Handler{0x0042: func(id uuid.UUID, r request.Reader) {
+				received <- inbound{id, r.GetRestAsBytes()}
+			}}
+		}))
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		_ = s.ServeConn(server)
+	}()
+	defer s.Shutdown(context.Background())
+
+	h, err := crypto.ReadHandshake(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != 83 || h.Patch != "2" || h.Locale != 9 {
+		t.Fatalf("Unexpected handshake %+v.", h)
+	}
+	send := crypto.NewAESOFB(h.RecvIv, h.Version)
+	recv := crypto.NewAESOFB(h.SendIv, 0xFFFF-h.Version)
+
+	packet := []byte{0x00, 0x00, 0x00, 0x00, 0x42, 0x00, 0xAA}
+	if err = crypto.EncryptPacket(send, packet); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Write(packet); err != nil {
+		t.Fatal(err)
+	}
+	var in inbound
+	select {
+	case in = <-received:
+		if !bytes.Equal(in.body, []byte{0xAA}) {
+			t.Fatalf("Unexpected payload [% X].", in.body)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Handler was not invoked.")
+	}
+
+	if err = s.Write(in.id, []byte{0x43, 0x00, 0xBB}); err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(client, header); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, recv.PacketLength(header))
+	if _, err = io.ReadFull(client, body); err != nil {
+		t.Fatal(err)
+	}
+	crypto.DecryptPacket(recv, body)
+	if !bytes.Equal(body, []byte{0x43, 0x00, 0xBB}) {
+		t.Errorf("Unexpected outbound packet [% X].", body)
+	}
+}
+
+func TestFailedHandshakeSkipsDestroyer(t *testing.T) {
+	var created, destroyed bool
+	s := New(SetLogger(testLogger()), SetReadWriter(ShortReadWriter{}),
+		SetCryptoProfile(crypto.CryptoProfile{Region: crypto.RegionGMS, Version: 83}),
+		SetCreator(func(_ uuid.UUID, _ net.Conn) {
+			created = true
+		}),
+		SetReasonDestroyer(func(_ uuid.UUID, _ DisconnectReason) {
+			destroyed = true
+		}))
+	client, server := net.Pipe()
+	_ = client.Close()
+	if err := s.ServeConn(server); err != nil {
+		t.Fatal(err)
+	}
+	if created || destroyed {
+		t.Errorf("Session failing the handshake reached created %v, destroyed %v.", created, destroyed)
+	}
+}
+
+func TestStartRejectsUnusableCryptoProfile(t *testing.T) {
+	s := New(SetLogger(testLogger()), SetIpAddress("127.0.0.1"), SetPort(0))
+	if err := s.AddListener("login", SetPort(0), SetCryptoProfile(crypto.CryptoProfile{Region: crypto.Region("NONE"), Version: 83})); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(context.Background()); !errors.Is(err, crypto.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v.", err)
+	}
+}
+
+func TestListenerHandlersReplaceInherited(t *testing.T) {
+	noop := func(_ uuid.UUID, _ request.Reader) {}
+	s := New(SetLogger(testLogger()), SetHandlers(func() map[uint16]request.Handler {
+		return map[uint16]request.Handler{0x0001: noop, 0x0002: noop}
+	}))
+	if err := s.AddListener("channel", SetHandlers(func() map[uint16]request.Handler {
+		return map[uint16]request.Handler{0x0003: noop}
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	handlers := s.listeners[0].config.handlers
+	if _, ok := handlers[0x0003]; len(handlers) != 1 || !ok {
+		t.Errorf("Expected the listener to serve only op 0x0003, got %d handlers.", len(handlers))
+	}
+	if len(s.config.handlers) != 2 {
+		t.Errorf("Listener handlers leaked into the server.")
+	}
+}
@@ -0,0 +1,43 @@
+package socket
+
+import (
+	"fmt"
+	"github.com/Chronicle20/atlas-socket/metrics"
+)
+
+const (
+	MetricConnectionsTotal         = "atlas_socket_connections_total"
+	MetricConnectionsActive        = "atlas_socket_connections_active"
+	MetricConnectionsRejectedTotal = "atlas_socket_connections_rejected_total"
+	MetricBytesReadTotal           = "atlas_socket_bytes_read_total"
+	MetricPacketsTotal             = "atlas_socket_packets_total"
+	MetricUnhandledTotal           = "atlas_socket_unhandled_packets_total"
+	MetricDecryptFailuresTotal     = "atlas_socket_decrypt_failures_total"
+	MetricHandlerDuration          = "atlas_socket_handler_duration_seconds"
+	MetricOutboundQueuedBytes      = "atlas_socket_outbound_queued_bytes"
+	MetricOutboundWritesTotal      = "atlas_socket_outbound_writes_total"
+	MetricBytesWrittenTotal        = "atlas_socket_bytes_written_total"
+	MetricSlowConsumersTotal       = "atlas_socket_slow_consumers_total"
+)
+
+// DescribeMetrics registers help text for the metrics emitted by the server.
+//
+//goland:noinspection GoUnusedExportedFunction
+func DescribeMetrics(r *metrics.Registry) {
+	r.Describe(MetricConnectionsTotal, "Connections accepted.")
+	r.Describe(MetricConnectionsActive, "Connections currently open.")
+	r.Describe(MetricConnectionsRejectedTotal, "Connections refused by admission control.")
+	r.Describe(MetricBytesReadTotal, "Bytes read from clients.")
+	r.Describe(MetricPacketsTotal, "Packets dispatched, by opcode.")
+	r.Describe(MetricUnhandledTotal, "Packets without a registered handler, by opcode.")
+	r.Describe(MetricDecryptFailuresTotal, "Packets the decryptor rejected.")
+	r.Describe(MetricHandlerDuration, "Handler execution time, by opcode.")
+	r.Describe(MetricOutboundQueuedBytes, "Bytes queued for writing across all sessions.")
+	r.Describe(MetricOutboundWritesTotal, "Writes to connections, each carrying one or more coalesced packets.")
+	r.Describe(MetricBytesWrittenTotal, "Bytes written to clients.")
+	r.Describe(MetricSlowConsumersTotal, "Sessions closed for not keeping up with outbound packets.")
+}
+
+func opLabel(op uint16) metrics.Label {
+	return metrics.Label{Name: "op", Value: fmt.Sprintf("0x%04X", op)}
+}
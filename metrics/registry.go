@@ -0,0 +1,283 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type kind byte
+
+const (
+	kindCounter kind = iota
+	kindGauge
+	kindHistogram
+)
+
+func (k kind) String() string {
+	switch k {
+	case kindGauge:
+		return "gauge"
+	case kindHistogram:
+		return "histogram"
+	default:
+		return "counter"
+	}
+}
+
+var (
+	ErrKindMismatch = errors.New("metric already recorded with a different kind")
+	ErrBucketsInUse = errors.New("histogram buckets cannot change after the first observation")
+)
+
+// DefaultBuckets are the upper bounds, in seconds, used for histograms without explicit buckets.
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+type series struct {
+	labels  []Label
+	value   float64
+	counts  []uint64
+	sum     float64
+	samples uint64
+}
+
+type family struct {
+	kind    kind
+	help    string
+	buckets []float64
+	series  map[string]*series
+}
+
+// Registry is a Sink which keeps measurements in memory and renders them in the Prometheus text exposition format. A metric keeps the kind it was first recorded with, measurements of another kind under the same name are dropped.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+//goland:noinspection GoUnusedExportedFunction
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// Describe sets the help text of a metric. It is optional.
+func (r *Registry) Describe(name string, help string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		f.help = help
+		return
+	}
+	r.families[name] = &family{help: help, series: make(map[string]*series)}
+}
+
+// SetBuckets overrides the histogram buckets of a metric. It fails with ErrBucketsInUse once the histogram has been observed, and with ErrKindMismatch when the name is recorded as another kind.
+func (r *Registry) SetBuckets(name string, buckets []float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.family(name, kindHistogram)
+	if !ok {
+		return ErrKindMismatch
+	}
+	if len(f.series) > 0 {
+		return ErrBucketsInUse
+	}
+	f.buckets = append([]float64(nil), buckets...)
+	sort.Float64s(f.buckets)
+	return nil
+}
+
+func (r *Registry) Add(name string, value float64, labels ...Label) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.family(name, kindCounter); ok {
+		r.series(f, labels).value += value
+	}
+}
+
+func (r *Registry) Set(name string, value float64, labels ...Label) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.family(name, kindGauge); ok {
+		r.series(f, labels).value = value
+	}
+}
+
+func (r *Registry) Observe(name string, value float64, labels ...Label) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.family(name, kindHistogram)
+	if !ok {
+		return
+	}
+	s := r.series(f, labels)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(f.buckets))
+	}
+	for i, b := range f.buckets {
+		if value <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.samples++
+}
+
+// family returns the metric for a measurement of the kind. The kind is fixed by the first series, so it reports false when the metric already holds series of another kind.
+func (r *Registry) family(name string, k kind) (*family, bool) {
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{series: make(map[string]*series)}
+		r.families[name] = f
+	}
+	if len(f.series) == 0 {
+		f.kind = k
+	}
+	if f.kind != k {
+		return nil, false
+	}
+	if f.kind == kindHistogram && f.buckets == nil {
+		f.buckets = DefaultBuckets
+	}
+	return f, true
+}
+
+func (r *Registry) series(f *family, labels []Label) *series {
+	sorted := append([]Label(nil), labels...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	key := formatLabels(sorted)
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: sorted}
+		f.series[key] = s
+	}
+	return s
+}
+
+// WriteTo renders all metrics in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sb := &strings.Builder{}
+	names := make([]string, 0, len(r.families))
+	for name, f := range r.families {
+		if len(f.series) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := r.families[name]
+		if f.help != "" {
+			_, _ = fmt.Fprintf(sb, "# HELP %s %s\n", name, escapeHelp(f.help))
+		}
+		_, _ = fmt.Fprintf(sb, "# TYPE %s %s\n", name, f.kind)
+
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			s := f.series[k]
+			if f.kind != kindHistogram {
+				_, _ = fmt.Fprintf(sb, "%s%s %s\n", name, k, formatFloat(s.value))
+				continue
+			}
+			for i, b := range f.buckets {
+				le := append(append([]Label(nil), s.labels...), Label{Name: "le", Value: formatFloat(b)})
+				_, _ = fmt.Fprintf(sb, "%s_bucket%s %d\n", name, formatLabels(le), s.counts[i])
+			}
+			inf := append(append([]Label(nil), s.labels...), Label{Name: "le", Value: "+Inf"})
+			_, _ = fmt.Fprintf(sb, "%s_bucket%s %d\n", name, formatLabels(inf), s.samples)
+			_, _ = fmt.Fprintf(sb, "%s_sum%s %s\n", name, k, formatFloat(s.sum))
+			_, _ = fmt.Fprintf(sb, "%s_count%s %d\n", name, k, s.samples)
+		}
+	}
+
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+// Serve exposes the registry at /metrics on the given address until ctx is cancelled.
+//
+//goland:noinspection GoUnusedExportedFunction
+func Serve(l logrus.FieldLogger, ctx context.Context, wg *sync.WaitGroup, address string, r *Registry) error {
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		l.WithError(err).Errorf("Unable to listen for metrics on [%s].", address)
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(sctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		l.Infof("Serving metrics on [%s].", lis.Addr())
+		err := srv.Serve(lis)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			l.WithError(err).Errorf("Metrics endpoint stopped.")
+		}
+	}()
+	return nil
+}
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels))
+	for _, l := range labels {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", l.Name, escapeLabel(l.Value)))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	if math.IsInf(v, -1) {
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer("\\", `\\`, "\"", `\"`, "\n", `\n`).Replace(v)
+}
+
+func escapeHelp(v string) string {
+	return strings.NewReplacer("\\", `\\`, "\n", `\n`).Replace(v)
+}
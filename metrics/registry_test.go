@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRegistryExposition(t *testing.T) {
+	r := NewRegistry()
+	r.Describe("requests_total", "Requests served.")
+	r.Add("requests_total", 1, Label{Name: "op", Value: "0x0001"})
+	r.Add("requests_total", 2, Label{Name: "op", Value: "0x0001"})
+	r.Set("active", 3)
+	if err := r.SetBuckets("latency_seconds", []float64{0.1, 1}); err != nil {
+		t.Fatal(err)
+	}
+	r.Observe("latency_seconds", 0.05)
+	r.Observe("latency_seconds", 0.5)
+	r.Observe("latency_seconds", 5)
+
+	sb := &strings.Builder{}
+	if _, err := r.WriteTo(sb); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# TYPE active gauge
+active 3
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.55
+latency_seconds_count 3
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{op="0x0001"} 3
+`
+	if sb.String() != expected {
+		t.Errorf("Unexpected exposition:\n%s", sb.String())
+	}
+}
+
+func TestRegistryLabelOrderAndEscaping(t *testing.T) {
+	r := NewRegistry()
+	r.Add("c", 1, Label{Name: "b", Value: "x"}, Label{Name: "a", Value: "\"q\""})
+	r.Add("c", 1, Label{Name: "a", Value: "\"q\""}, Label{Name: "b", Value: "x"})
+
+	sb := &strings.Builder{}
+	_, _ = r.WriteTo(sb)
+	if !strings.Contains(sb.String(), `c{a="\"q\"",b="x"} 2`) {
+		t.Errorf("Unexpected exposition:\n%s", sb.String())
+	}
+}
+
+func TestRegistryRejectsKindMismatch(t *testing.T) {
+	r := NewRegistry()
+	r.Add("packets", 1)
+	r.Observe("packets", 0.5)
+	r.Set("packets", 7)
+	if err := r.SetBuckets("packets", []float64{1}); !errors.Is(err, ErrKindMismatch) {
+		t.Errorf("Expected ErrKindMismatch, got %v.", err)
+	}
+
+	sb := &strings.Builder{}
+	if _, err := r.WriteTo(sb); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "# TYPE packets counter\npackets 1\n"; sb.String() != expected {
+		t.Errorf("Unexpected exposition:\n%s", sb.String())
+	}
+}
+
+func TestRegistryKeepsBucketsAfterObservation(t *testing.T) {
+	r := NewRegistry()
+	r.Observe("latency_seconds", 0.01)
+
+	// More buckets than the defaults, which would outgrow the counts of the existing series.
+	buckets := append(append([]float64(nil), DefaultBuckets...), 10000)
+	if err := r.SetBuckets("latency_seconds", buckets); !errors.Is(err, ErrBucketsInUse) {
+		t.Errorf("Expected ErrBucketsInUse, got %v.", err)
+	}
+	r.Observe("latency_seconds", 0.02, Label{Name: "op", Value: "0x0001"})
+	r.Observe("latency_seconds", 0.03)
+
+	sb := &strings.Builder{}
+	if _, err := r.WriteTo(sb); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), "latency_seconds_count 2\n") || strings.Contains(sb.String(), `le="10000"`) {
+		t.Errorf("Unexpected exposition:\n%s", sb.String())
+	}
+}
@@ -0,0 +1,28 @@
+package metrics
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sink receives measurements from the socket server. Implementations must be safe for concurrent use.
+type Sink interface {
+	// Add increments a counter.
+	Add(name string, value float64, labels ...Label)
+	// Set replaces the value of a gauge.
+	Set(name string, value float64, labels ...Label)
+	// Observe records a sample into a histogram.
+	Observe(name string, value float64, labels ...Label)
+}
+
+type NoopSink struct {
+}
+
+func (n NoopSink) Add(_ string, _ float64, _ ...Label) {
+}
+
+func (n NoopSink) Set(_ string, _ float64, _ ...Label) {
+}
+
+func (n NoopSink) Observe(_ string, _ float64, _ ...Label) {
+}
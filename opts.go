@@ -1,5 +1,17 @@
 package socket
 
+import (
+	"fmt"
+	"github.com/Chronicle20/atlas-socket/capture"
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"github.com/Chronicle20/atlas-socket/metrics"
+	"github.com/Chronicle20/atlas-socket/request"
+	"github.com/Chronicle20/atlas-socket/tracing"
+	"github.com/sirupsen/logrus"
+	"net"
+	"time"
+)
+
 type Configurator func(s *config)
 
 //goland:noinspection GoUnusedExportedFunction
@@ -25,6 +37,15 @@ func SetCreator(creator Creator) Configurator {
 
 //goland:noinspection GoUnusedExportedFunction
 func SetDestroyer(destroyer Destroyer) Configurator {
+	return func(s *config) {
+		s.destroyer = adaptDestroyer(destroyer)
+	}
+}
+
+// SetReasonDestroyer registers a destroyer which also receives why the session ended. It replaces any destroyer set through SetDestroyer. Like the creator, it is not called for sessions which fail the crypto profile handshake.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetReasonDestroyer(destroyer ReasonDestroyer) Configurator {
 	return func(s *config) {
 		s.destroyer = destroyer
 	}
@@ -44,8 +65,261 @@ func SetReadWriter(rw OpReadWriter) Configurator {
 	}
 }
 
+// SetHandlers replaces the handler table with handlers using the original signature, adapted to ContextHandler.
 func SetHandlers(producer HandlerProducer) Configurator {
 	return func(s *config) {
-		s.handlers = producer()
+		handlers := make(map[uint16]request.ContextHandler)
+		for op, h := range producer() {
+			handlers[op] = request.AdaptHandler(h)
+		}
+		s.handlers = handlers
+	}
+}
+
+//goland:noinspection GoUnusedExportedFunction
+func SetMetricsSink(sink metrics.Sink) Configurator {
+	return func(s *config) {
+		s.metrics = sink
+	}
+}
+
+// SetContextHandlers adds handlers which receive the per-packet context to the handler table, replacing any handler for the same op. Apply it after SetHandlers, which replaces the whole table.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetContextHandlers(producer ContextHandlerProducer) Configurator {
+	return func(s *config) {
+		for op, h := range producer() {
+			s.handlers[op] = h
+		}
+	}
+}
+
+// SetHandlerTimeout bounds the per-packet context with a deadline. Zero, the default, means no deadline.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetHandlerTimeout(timeout time.Duration) Configurator {
+	return func(s *config) {
+		s.timeout = timeout
+	}
+}
+
+// SetPanicHandler recovers panics raised by handlers. The handler is told of the panic and the session is closed with DisconnectHandlerPanic, as the handler may have left session state half-written. Without one a handler panic is not recovered.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetPanicHandler(handler PanicHandler) Configurator {
+	return func(s *config) {
+		s.panics = handler
+	}
+}
+
+//goland:noinspection GoUnusedExportedFunction
+func SetTracer(tracer tracing.Tracer) Configurator {
+	return func(s *config) {
+		s.tracer = tracer
+	}
+}
+
+// SetOpNames supplies human-readable opcode names used in traces.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetOpNames(names map[uint16]string) Configurator {
+	return func(s *config) {
+		s.opNames = names
+	}
+}
+
+// SetMaxPacketSize bounds the body length a client may announce. Sessions announcing larger packets are closed as a protocol violation before the body is allocated.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetMaxPacketSize(size int) Configurator {
+	return func(s *config) {
+		s.maxPacketSize = size
+	}
+}
+
+// SetMessageEncryptor encrypts packets written through Session.Write. Without one packets are written as supplied.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetMessageEncryptor(encryptor MessageEncryptor) Configurator {
+	return func(s *config) {
+		s.encryptor = encryptor
+	}
+}
+
+// SetOutbound bounds the per-session outbound queue. Zero fields keep their defaults.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetOutbound(cfg OutboundConfig) Configurator {
+	return func(s *config) {
+		if cfg.Limit > 0 {
+			s.outbound.Limit = cfg.Limit
+		}
+		if cfg.HighWater > 0 {
+			s.outbound.HighWater = cfg.HighWater
+		}
+		if cfg.EvictAfter > 0 {
+			s.outbound.EvictAfter = cfg.EvictAfter
+		}
+		if cfg.WriteTimeout > 0 {
+			s.outbound.WriteTimeout = cfg.WriteTimeout
+		}
+		if cfg.MaxBatch > 0 {
+			s.outbound.MaxBatch = cfg.MaxBatch
+		}
+	}
+}
+
+// SetDebugReads hands handlers debug readers and logs, at debug level, an annotated trace of the fields each handler read. Unhandled packets are logged as a hex dump. Tracing allocates per field, so this is meant for development.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetDebugReads(enabled bool) Configurator {
+	return func(s *config) {
+		s.debugReads = enabled
+	}
+}
+
+// SetPacketRateLimit limits the packets per second of each session across all ops. Start fails unless Rate is positive and Burst is at least one.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetPacketRateLimit(limit RateLimit) Configurator {
+	return func(s *config) {
+		s.packetLimit = &limit
+	}
+}
+
+// SetOpRateLimit limits the packets per second of each session for a single op. Op limits are checked before the session wide limit. Start fails unless Rate is positive and Burst is at least one.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetOpRateLimit(op uint16, limit RateLimit) Configurator {
+	return func(s *config) {
+		s.opLimits[op] = limit
+	}
+}
+
+// SetMaxConnectionsPerIp caps concurrent connections from a single address. Zero, the default, means unlimited.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetMaxConnectionsPerIp(max int) Configurator {
+	return func(s *config) {
+		s.maxPerIp = max
+	}
+}
+
+//goland:noinspection GoUnusedExportedFunction
+func SetRateLimitHook(hook RateLimitHook) Configurator {
+	return func(s *config) {
+		s.limitHook = hook
+	}
+}
+
+//goland:noinspection GoUnusedExportedFunction
+func SetAdmission(admission Admission) Configurator {
+	return func(s *config) {
+		s.admission = admission
+	}
+}
+
+//goland:noinspection GoUnusedExportedFunction
+func SetRejectPacketProducer(producer RejectPacketProducer) Configurator {
+	return func(s *config) {
+		s.rejectPacket = producer
+	}
+}
+
+// SetProxyProtocol enables PROXY protocol v1 and v2 for connections from the trusted CIDRs. Such connections must begin with a header, and the client address it carries is used for logging, admission and rate limits. Connections from other addresses are treated as direct. It panics on an invalid CIDR, as a misconfigured proxy would otherwise be silently treated as a direct client.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetProxyProtocol(trusted ...string) Configurator {
+	nets := make([]*net.IPNet, 0, len(trusted))
+	for _, cidr := range trusted {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(fmt.Sprintf("socket: invalid trusted proxy CIDR [%s]: %v", cidr, err))
+		}
+		nets = append(nets, n)
+	}
+	return func(s *config) {
+		s.trustedProxies = append(s.trustedProxies, nets...)
+	}
+}
+
+// SetProxyHeaderTimeout bounds how long a trusted proxy may take to send the PROXY protocol header.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetProxyHeaderTimeout(timeout time.Duration) Configurator {
+	return func(s *config) {
+		s.proxyTimeout = timeout
+	}
+}
+
+// SetIdleTimeout closes sessions which send nothing for the duration. Zero, the default, disables the timeout.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetIdleTimeout(timeout time.Duration) Configurator {
+	return func(s *config) {
+		s.idleTimeout = timeout
+	}
+}
+
+//goland:noinspection GoUnusedExportedFunction
+func SetPing(ping PingConfig) Configurator {
+	return func(s *config) {
+		if ping.MaxMissed <= 0 {
+			ping.MaxMissed = 1
+		}
+		s.ping = &ping
+	}
+}
+
+// SetKeepAlive configures TCP keepalive on accepted sockets.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetKeepAlive(keepAlive net.KeepAliveConfig) Configurator {
+	return func(s *config) {
+		s.keepAlive = &keepAlive
+	}
+}
+
+//goland:noinspection GoUnusedExportedFunction
+func SetLogger(l logrus.FieldLogger) Configurator {
+	return func(s *config) {
+		s.l = l
+	}
+}
+
+// SetCryptoProfile makes the server own the crypto of the listener. Each session is sent the hello handshake with fresh IVs before the Creator runs, and packets are decrypted and encrypted with ciphers built from the profile instead of the MessageDecryptor and MessageEncryptor. Creators must not write a handshake of their own. Start fails if no cipher can be built for the profile.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetCryptoProfile(profile crypto.CryptoProfile) Configurator {
+	return func(s *config) {
+		s.profile = &profile
+	}
+}
+
+// SetHandshake sets the patch version and locale sent in the hello handshake of listeners with a crypto profile. The defaults are patch "1" and locale 8.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetHandshake(patch string, locale byte) Configurator {
+	return func(s *config) {
+		s.patch = patch
+		s.locale = locale
+	}
+}
+
+// SetListener accepts connections from a caller supplied listener, such as a socket activated or Unix domain listener, instead of binding the configured address. The server closes it on shutdown.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetListener(listener net.Listener) Configurator {
+	return func(s *config) {
+		s.listener = listener
+	}
+}
+
+// SetCapture records every decrypted inbound packet and every packet written through Write or WriteAndClose, in plaintext before the MessageEncryptor. Pre-encrypted packets passed to CloseWithPacket and packets services write to the connection directly are not seen.
+//
+//goland:noinspection GoUnusedExportedFunction
+func SetCapture(recorder capture.Recorder) Configurator {
+	return func(s *config) {
+		s.capture = recorder
 	}
 }
@@ -0,0 +1,276 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"github.com/Chronicle20/atlas-socket/capture"
+	"github.com/Chronicle20/atlas-socket/request"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrQueueFull      = errors.New("outbound queue full")
+	ErrSessionClosed  = errors.New("session closed")
+	ErrPacketRejected = errors.New("packet rejected by encryptor")
+)
+
+// MessageEncryptor encrypts an outbound packet, op included, producing the bytes written to the connection. It is called in queue order, so IV rotation matches the order packets are written. Returning nil rejects the packet.
+type MessageEncryptor func(sessionId uuid.UUID, packet []byte) []byte
+
+func defaultMessageEncryptor(_ uuid.UUID, packet []byte) []byte {
+	return packet
+}
+
+// OutboundConfig bounds the queue behind Session.Write. Sizes are in bytes as written to the connection.
+type OutboundConfig struct {
+	// Limit is the most a session may have queued. A write which would exceed it fails and the session is closed as a slow consumer. Packets are measured before encryption for this check.
+	Limit int
+	// HighWater is the depth above which a session is considered backed up. Sessions which stay above it for EvictAfter are closed as slow consumers.
+	HighWater  int
+	EvictAfter time.Duration
+	// WriteTimeout is the deadline for each write to the connection.
+	WriteTimeout time.Duration
+	// MaxBatch is the most bytes coalesced into a single write. A larger packet is written on its own.
+	MaxBatch int
+}
+
+// DefaultOutboundConfig is used unless SetOutbound is supplied.
+var DefaultOutboundConfig = OutboundConfig{
+	Limit:        1 << 20,
+	HighWater:    256 << 10,
+	EvictAfter:   10 * time.Second,
+	WriteTimeout: 10 * time.Second,
+	MaxBatch:     16 << 10,
+}
+
+type outboundFrame struct {
+	data    []byte
+	flushed chan error
+}
+
+// outboundQueue serializes writes to a session's connection. Packets are queued by any goroutine and written, coalesced, by a single writer.
+type outboundQueue struct {
+	l         logrus.FieldLogger
+	sessionId uuid.UUID
+	conn      net.Conn
+	config    *config
+	cfg       OutboundConfig
+	encryptor MessageEncryptor
+	evict     context.CancelCauseFunc
+
+	mu        sync.Mutex
+	frames    []outboundFrame
+	queued    int
+	overSince time.Time
+	closed    bool
+	signal    chan struct{}
+	evicted   atomic.Bool
+}
+
+func newOutboundQueue(l logrus.FieldLogger, config *config, sessionId uuid.UUID, conn net.Conn, encryptor MessageEncryptor, evict context.CancelCauseFunc) *outboundQueue {
+	return &outboundQueue{
+		l:         l,
+		sessionId: sessionId,
+		conn:      conn,
+		config:    config,
+		cfg:       config.outbound,
+		encryptor: encryptor,
+		evict:     evict,
+		signal:    make(chan struct{}, 1),
+	}
+}
+
+// enqueue queues a packet, passing it through the encryptor unless it is raw. The limit is checked before encrypting, so a refused packet does not advance the IV. flushed, if not nil, receives the result of the write which carried it.
+func (q *outboundQueue) enqueue(packet []byte, raw bool, flushed chan error) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return ErrSessionClosed
+	}
+	if q.queued+len(packet) > q.cfg.Limit {
+		q.mu.Unlock()
+		q.l.Warnf("Closing slow consumer with [%d] bytes queued.", q.queued)
+		q.slowConsumer(ErrQueueFull)
+		return ErrQueueFull
+	}
+	data := packet
+	if !raw {
+		if data = q.encryptor(q.sessionId, packet); data == nil {
+			q.mu.Unlock()
+			return ErrPacketRejected
+		}
+		if q.config.capture != nil {
+			q.record(packet)
+		}
+	}
+	q.frames = append(q.frames, outboundFrame{data: data, flushed: flushed})
+	q.queued += len(data)
+	if q.queued >= q.cfg.HighWater && q.overSince.IsZero() {
+		q.overSince = time.Now()
+	}
+	q.mu.Unlock()
+
+	q.config.metrics.Set(MetricOutboundQueuedBytes, float64(q.config.stats.queued.Add(int64(len(data)))))
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// record captures an outbound packet in plaintext. It is called under the queue lock so records follow the order packets are written.
+func (q *outboundQueue) record(packet []byte) {
+	p := request.Request(packet)
+	r := request.NewRequestReader(&p, time.Now().Unix())
+	op := q.config.rw.Read(&r)
+	q.config.capture.Record(capture.Record{Time: time.Now(), Direction: capture.Outbound, SessionId: q.sessionId, Op: op, Data: append(capture.Bytes(nil), packet...)})
+}
+
+func (q *outboundQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queued
+}
+
+// take removes the next batch, coalescing queued frames up to MaxBatch bytes.
+func (q *outboundQueue) take() ([]byte, []outboundFrame) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.frames) == 0 {
+		return nil, nil
+	}
+	if len(q.frames) == 1 || len(q.frames[0].data) >= q.cfg.MaxBatch {
+		f := q.frames[0]
+		q.frames = q.frames[1:]
+		return f.data, []outboundFrame{f}
+	}
+
+	n, size := 0, 0
+	for n < len(q.frames) && size+len(q.frames[n].data) <= q.cfg.MaxBatch {
+		size += len(q.frames[n].data)
+		n++
+	}
+	batch := make([]byte, 0, size)
+	for _, f := range q.frames[:n] {
+		batch = append(batch, f.data...)
+	}
+	taken := q.frames[:n:n]
+	q.frames = q.frames[n:]
+	return batch, taken
+}
+
+// written accounts for a completed write.
+func (q *outboundQueue) written(size int) {
+	q.mu.Lock()
+	q.queued -= size
+	if q.queued < q.cfg.HighWater {
+		q.overSince = time.Time{}
+	}
+	q.mu.Unlock()
+	q.config.metrics.Set(MetricOutboundQueuedBytes, float64(q.config.stats.queued.Add(-int64(size))))
+}
+
+func (q *outboundQueue) backedUp(now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return !q.overSince.IsZero() && now.Sub(q.overSince) >= q.cfg.EvictAfter
+}
+
+func (q *outboundQueue) slowConsumer(err error) {
+	if q.evicted.Swap(true) {
+		return
+	}
+	q.config.metrics.Add(MetricSlowConsumersTotal, 1)
+	q.config.stats.slowConsumers.Add(1)
+	q.evict(DisconnectReason{Category: DisconnectSlowConsumer, Err: err})
+}
+
+// run writes queued packets until ctx ends, then fails anything left in the queue.
+func (q *outboundQueue) run(ctx context.Context) {
+	defer q.shutdown()
+	go q.watch(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.signal:
+		}
+
+		for {
+			batch, frames := q.take()
+			if frames == nil {
+				break
+			}
+			_ = q.conn.SetWriteDeadline(time.Now().Add(q.cfg.WriteTimeout))
+			n, err := q.conn.Write(batch)
+			q.config.metrics.Add(MetricOutboundWritesTotal, 1)
+			if n > 0 {
+				q.config.metrics.Add(MetricBytesWrittenTotal, float64(n))
+				q.config.stats.bytesWritten.Add(uint64(n))
+			}
+			q.written(len(batch))
+			for _, f := range frames {
+				if f.flushed != nil {
+					f.flushed <- err
+				}
+			}
+			if err != nil {
+				if os.IsTimeout(err) {
+					q.l.Warnf("Closing slow consumer which did not accept a write within [%s].", q.cfg.WriteTimeout)
+					q.slowConsumer(err)
+				} else if ctx.Err() == nil {
+					q.l.WithError(err).Errorf("Error writing to connection.")
+					q.evict(DisconnectReason{Category: DisconnectWriteError, Err: err})
+				}
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+// watch closes the session once the queue has stayed above the high-water mark for EvictAfter. It runs apart from the writer, which may be blocked on a client that stopped reading.
+func (q *outboundQueue) watch(ctx context.Context) {
+	t := time.NewTicker(max(q.cfg.EvictAfter/4, 10*time.Millisecond))
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			if q.backedUp(now) {
+				q.l.Warnf("Closing slow consumer above the high-water mark for [%s].", q.cfg.EvictAfter)
+				q.slowConsumer(ErrQueueFull)
+				return
+			}
+		}
+	}
+}
+
+func (q *outboundQueue) shutdown() {
+	q.mu.Lock()
+	q.closed = true
+	frames := q.frames
+	q.frames = nil
+	remaining := q.queued
+	q.queued = 0
+	q.mu.Unlock()
+
+	if remaining != 0 {
+		q.config.metrics.Set(MetricOutboundQueuedBytes, float64(q.config.stats.queued.Add(-int64(remaining))))
+	}
+	for _, f := range frames {
+		if f.flushed != nil {
+			f.flushed <- ErrSessionClosed
+		}
+	}
+}
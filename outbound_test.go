@@ -0,0 +1,251 @@
+package socket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/google/uuid"
+	"io"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func expectReason(t *testing.T, reasons chan DisconnectReason, category DisconnectCategory) DisconnectReason {
+	t.Helper()
+	select {
+	case r := <-reasons:
+		if r.Category != category {
+			t.Fatalf("Expected [%s], got [%s].", category, r)
+		}
+		return r
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Session was not closed.")
+	}
+	return DisconnectReason{}
+}
+
+// waitTaken waits until the writer has taken every queued frame.
+func waitTaken(t *testing.T, q *outboundQueue) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		q.mu.Lock()
+		n := len(q.frames)
+		q.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Writer did not take the queued frames.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOutboundCoalescesQueuedPackets(t *testing.T) {
+	s, id, client, _ := startTestSession(t)
+	ses, _ := s.Session(id)
+
+	if err := ses.Write([]byte{0xAA}); err != nil {
+		t.Fatal(err)
+	}
+	// Once the writer has taken the first packet it blocks until the client reads, so the next packets queue up behind it.
+	waitTaken(t, ses.out)
+	for i := 0; i < 10; i++ {
+		if err := ses.Write([]byte{0x01, byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ses.QueueDepth() != 21 {
+		t.Errorf("Expected depth of 21 bytes, got [%d].", ses.QueueDepth())
+	}
+
+	buf := make([]byte, 64)
+	n, err := client.Read(buf)
+	if err != nil || n != 1 {
+		t.Fatalf("Expected the first packet alone, got [%d] bytes [%v].", n, err)
+	}
+	n, err = client.Read(buf)
+	if err != nil || n != 20 {
+		t.Fatalf("Expected ten packets in one write, got [%d] bytes [%v].", n, err)
+	}
+	if buf[0] != 0x01 || buf[1] != 0x00 || buf[18] != 0x01 || buf[19] != 0x09 {
+		t.Errorf("Packets out of order [% X].", buf[:n])
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for s.Stats().QueuedBytes != 0 || s.Stats().BytesWritten != 21 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Unexpected stats [%+v].", s.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOutboundEncryptsInQueueOrder(t *testing.T) {
+	var counter atomic.Uint32
+	s, id, client, _ := startTestSession(t, SetMessageEncryptor(func(_ uuid.UUID, packet []byte) []byte {
+		return binary.LittleEndian.AppendUint32(nil, counter.Add(1))
+	}))
+
+	go func() {
+		for i := 0; i < 50; i++ {
+			_ = s.Write(id, []byte{0x00})
+		}
+	}()
+	buf := make([]byte, 200)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if v := binary.LittleEndian.Uint32(buf[i*4:]); v != uint32(i+1) {
+			t.Fatalf("Packet [%d] carries sequence [%d].", i, v)
+		}
+	}
+}
+
+func TestOutboundEvictsSessionOverHighWater(t *testing.T) {
+	s, id, _, reasons := startTestSession(t, SetOutbound(OutboundConfig{HighWater: 8, EvictAfter: 50 * time.Millisecond}))
+	for i := 0; i < 4; i++ {
+		if err := s.Write(id, make([]byte, 4)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := expectReason(t, reasons, DisconnectSlowConsumer)
+	if !errors.Is(r, ErrQueueFull) {
+		t.Errorf("Unexpected reason [%s].", r)
+	}
+	if s.Stats().SlowConsumers != 1 {
+		t.Errorf("Expected one slow consumer, got [%+v].", s.Stats())
+	}
+	if err := s.Write(id, []byte{0x01}); !errors.Is(err, ErrSessionNotFound) && !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Expected write to closed session to fail, got [%v].", err)
+	}
+}
+
+func TestOutboundLimitRejectsWrite(t *testing.T) {
+	s, id, _, reasons := startTestSession(t, SetOutbound(OutboundConfig{Limit: 16}))
+	ses, _ := s.Session(id)
+
+	var err error
+	for i := 0; i < 8 && err == nil; i++ {
+		err = ses.Write(make([]byte, 4))
+	}
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected ErrQueueFull, got [%v].", err)
+	}
+	expectReason(t, reasons, DisconnectSlowConsumer)
+}
+
+func TestOutboundWriteDeadline(t *testing.T) {
+	s, id, _, reasons := startTestSession(t, SetOutbound(OutboundConfig{WriteTimeout: 50 * time.Millisecond}))
+	if err := s.Write(id, []byte{0x01}); err != nil {
+		t.Fatal(err)
+	}
+	r := expectReason(t, reasons, DisconnectSlowConsumer)
+	if !errors.Is(r, os.ErrDeadlineExceeded) {
+		t.Errorf("Unexpected reason [%s].", r)
+	}
+}
+
+func TestCloseWithPacketFlushesQueue(t *testing.T) {
+	s, id, client, reasons := startTestSession(t)
+	if err := s.Write(id, []byte{0x01}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.CloseWithPacket(id, DisconnectReason{Category: DisconnectKicked}, []byte{0x02})
+	}()
+	received, _ := io.ReadAll(client)
+	if !bytes.Equal(received, []byte{0x01, 0x02}) {
+		t.Errorf("Expected queued and final packet, got [% X].", received)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error [%v].", err)
+	}
+	expectReason(t, reasons, DisconnectKicked)
+}
+
+func TestOutboundLimitCheckedBeforeEncrypting(t *testing.T) {
+	var calls atomic.Int32
+	s, id, _, reasons := startTestSession(t, SetOutbound(OutboundConfig{Limit: 16}), SetMessageEncryptor(func(_ uuid.UUID, packet []byte) []byte {
+		calls.Add(1)
+		return packet
+	}))
+	ses, _ := s.Session(id)
+
+	var err error
+	accepted := 0
+	for ; accepted < 8; accepted++ {
+		if err = ses.Write(make([]byte, 4)); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected ErrQueueFull, got [%v].", err)
+	}
+	if int(calls.Load()) != accepted {
+		t.Errorf("Encryptor ran [%d] times for [%d] accepted packets.", calls.Load(), accepted)
+	}
+	expectReason(t, reasons, DisconnectSlowConsumer)
+}
+
+func TestCloseWithPacketBypassesEncryptor(t *testing.T) {
+	invert := SetMessageEncryptor(func(_ uuid.UUID, packet []byte) []byte {
+		out := make([]byte, len(packet))
+		for i, b := range packet {
+			out[i] = ^b
+		}
+		return out
+	})
+	for _, tc := range []struct {
+		name     string
+		close    func(s *Server, id uuid.UUID) error
+		expected []byte
+	}{
+		{"pre-encrypted", func(s *Server, id uuid.UUID) error {
+			return s.CloseWithPacket(id, DisconnectReason{Category: DisconnectKicked}, []byte{0x02})
+		}, []byte{0xFE, 0x02}},
+		{"plaintext", func(s *Server, id uuid.UUID) error {
+			return s.WriteAndClose(id, DisconnectReason{Category: DisconnectKicked}, []byte{0x02})
+		}, []byte{0xFE, 0xFD}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, id, client, reasons := startTestSession(t, invert)
+			if err := s.Write(id, []byte{0x01}); err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan error, 1)
+			go func() {
+				done <- tc.close(s, id)
+			}()
+			received, _ := io.ReadAll(client)
+			if !bytes.Equal(received, tc.expected) {
+				t.Errorf("Expected [% X], got [% X].", tc.expected, received)
+			}
+			if err := <-done; err != nil {
+				t.Errorf("Unexpected error [%v].", err)
+			}
+			expectReason(t, reasons, DisconnectKicked)
+		})
+	}
+}
+
+func TestCloseWithPacketBoundsFlushWait(t *testing.T) {
+	s, id, _, reasons := startTestSession(t)
+
+	// The client never reads, so the final packet cannot be written before the ten second write timeout.
+	start := time.Now()
+	err := s.CloseWithPacket(id, DisconnectReason{Category: DisconnectKicked}, []byte{0x01})
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected os.ErrDeadlineExceeded, got [%v].", err)
+	}
+	if elapsed := time.Since(start); elapsed > closeFlushTimeout+500*time.Millisecond {
+		t.Errorf("Close waited [%s].", elapsed)
+	}
+	expectReason(t, reasons, DisconnectKicked)
+}
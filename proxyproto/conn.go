@@ -0,0 +1,84 @@
+package proxyproto
+
+import (
+	"bufio"
+	"net"
+	"time"
+)
+
+// Conn reports the client address carried by a PROXY protocol header. Bytes buffered while parsing the header are returned by Read before the underlying connection is read again.
+type Conn struct {
+	net.Conn
+	r      *bufio.Reader
+	header Header
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.r.Buffered() > 0 {
+		return c.r.Read(b)
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	if c.header.Destination != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// ProxyAddr is the address of the proxy which relayed the connection.
+func (c *Conn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) Header() Header {
+	return c.header
+}
+
+// Wrap reads the header from conn within the timeout. The connection is left open on error.
+func Wrap(conn net.Conn, timeout time.Duration) (*Conn, error) {
+	if timeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		defer func() {
+			_ = conn.SetReadDeadline(time.Time{})
+		}()
+	}
+
+	r := bufio.NewReaderSize(conn, 256)
+	h, err := Read(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn, r: r, header: h}, nil
+}
+
+// Trusted reports whether addr falls within any of the networks.
+func Trusted(addr net.Addr, networks []*net.IPNet) bool {
+	var ip net.IP
+	switch v := addr.(type) {
+	case *net.TCPAddr:
+		ip = v.IP
+	case *net.UDPAddr:
+		ip = v.IP
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return false
+		}
+		ip = net.ParseIP(host)
+	}
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
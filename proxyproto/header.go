@@ -0,0 +1,152 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNoHeader       = errors.New("proxy protocol header missing")
+	ErrInvalidHeader  = errors.New("invalid proxy protocol header")
+	ErrUnsupported    = errors.New("unsupported proxy protocol family")
+	v1Prefix          = []byte("PROXY ")
+	v2Signature       = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+	maxV1HeaderLength = 107
+)
+
+// Header is a parsed PROXY protocol header. Source and Destination are nil for LOCAL and AF_UNIX (v2) and UNKNOWN (v1) headers, in which case the connection addresses should be used.
+type Header struct {
+	Version     byte
+	Source      net.Addr
+	Destination net.Addr
+}
+
+// Read consumes a v1 or v2 header from r.
+func Read(r *bufio.Reader) (Header, error) {
+	b, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		return Header{}, err
+	}
+	if bytes.Equal(b, v1Prefix) {
+		return readV1(r)
+	}
+
+	b, err = r.Peek(len(v2Signature))
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return Header{}, ErrNoHeader
+		}
+		return Header{}, err
+	}
+	if bytes.Equal(b, v2Signature) {
+		return readV2(r)
+	}
+	return Header{}, ErrNoHeader
+}
+
+func readV1(r *bufio.Reader) (Header, error) {
+	line := make([]byte, 0, maxV1HeaderLength)
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return Header{}, err
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+		if len(line) >= maxV1HeaderLength {
+			return Header{}, ErrInvalidHeader
+		}
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return Header{}, ErrInvalidHeader
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return Header{Version: 1}, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return Header{}, ErrInvalidHeader
+	}
+
+	src, err := parseV1Addr(fields[2], fields[4], fields[1] == "TCP4")
+	if err != nil {
+		return Header{}, err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5], fields[1] == "TCP4")
+	if err != nil {
+		return Header{}, err
+	}
+	return Header{Version: 1, Source: src, Destination: dst}, nil
+}
+
+func parseV1Addr(ip string, port string, v4 bool) (net.Addr, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || (parsed.To4() != nil) != v4 {
+		return nil, fmt.Errorf("%w: address [%s]", ErrInvalidHeader, ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: port [%s]", ErrInvalidHeader, port)
+	}
+	return &net.TCPAddr{IP: parsed, Port: int(p)}, nil
+}
+
+func readV2(r *bufio.Reader) (Header, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return Header{}, err
+	}
+	if fixed[12]>>4 != 2 {
+		return Header{}, ErrInvalidHeader
+	}
+	command := fixed[12] & 0x0F
+	family := fixed[13]
+	length := int(binary.BigEndian.Uint16(fixed[14:16]))
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return Header{}, err
+	}
+
+	if command == 0 {
+		return Header{Version: 2}, nil
+	}
+	if command != 1 {
+		return Header{}, ErrInvalidHeader
+	}
+
+	var size int
+	switch family {
+	case 0x11, 0x12:
+		size = net.IPv4len
+	case 0x21, 0x22:
+		size = net.IPv6len
+	case 0x00, 0x31, 0x32:
+		// UNSPEC and AF_UNIX carry no address usable as a client address, the block is skipped.
+		return Header{Version: 2}, nil
+	default:
+		return Header{}, ErrUnsupported
+	}
+	if len(body) < size*2+4 {
+		return Header{}, ErrInvalidHeader
+	}
+
+	src := net.IP(append([]byte(nil), body[:size]...))
+	dst := net.IP(append([]byte(nil), body[size:size*2]...))
+	sport := int(binary.BigEndian.Uint16(body[size*2:]))
+	dport := int(binary.BigEndian.Uint16(body[size*2+2:]))
+	if family&0x0F == 0x02 {
+		return Header{Version: 2, Source: &net.UDPAddr{IP: src, Port: sport}, Destination: &net.UDPAddr{IP: dst, Port: dport}}, nil
+	}
+	return Header{Version: 2, Source: &net.TCPAddr{IP: src, Port: sport}, Destination: &net.TCPAddr{IP: dst, Port: dport}}, nil
+}
@@ -0,0 +1,124 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestReadV1(t *testing.T) {
+	r := bufio.NewReader(bytes.NewReader([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 8484\r\nHELLO")))
+	h, err := Read(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != 1 || h.Source.String() != "203.0.113.7:51234" || h.Destination.String() != "10.0.0.1:8484" {
+		t.Fatalf("Unexpected header %+v.", h)
+	}
+	rest, _ := io.ReadAll(r)
+	if string(rest) != "HELLO" {
+		t.Errorf("Header parsing consumed payload, rest [%s].", rest)
+	}
+}
+
+func TestReadV1Unknown(t *testing.T) {
+	h, err := Read(bufio.NewReader(bytes.NewReader([]byte("PROXY UNKNOWN\r\n"))))
+	if err != nil || h.Source != nil {
+		t.Fatalf("Expected UNKNOWN header without addresses, got %+v %v.", h, err)
+	}
+}
+
+func TestReadV1Invalid(t *testing.T) {
+	for _, in := range []string{
+		"PROXY TCP4 203.0.113.7 10.0.0.1 51234\r\n",
+		"PROXY TCP4 ::1 ::1 1 2\r\n",
+		"PROXY TCP4 203.0.113.7 10.0.0.1 99999 8484\r\n",
+		"PROXY TCP4 203.0.113.7 10.0.0.1 1 2\n",
+	} {
+		if _, err := Read(bufio.NewReader(bytes.NewReader([]byte(in)))); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("[%q] expected ErrInvalidHeader, got %v.", in, err)
+		}
+	}
+}
+
+func v2Header(command byte, family byte, body []byte) []byte {
+	b := append([]byte(nil), v2Signature...)
+	b = append(b, 0x20|command, family, byte(len(body)>>8), byte(len(body)))
+	return append(b, body...)
+}
+
+func TestReadV2(t *testing.T) {
+	body := []byte{203, 0, 113, 7, 10, 0, 0, 1, 0xC8, 0x22, 0x21, 0x24, 0xAA, 0xBB}
+	r := bufio.NewReader(bytes.NewReader(append(v2Header(1, 0x11, body), 'X')))
+	h, err := Read(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != 2 || h.Source.String() != "203.0.113.7:51234" || h.Destination.String() != "10.0.0.1:8484" {
+		t.Fatalf("Unexpected header %+v.", h)
+	}
+	if c, _ := r.ReadByte(); c != 'X' {
+		t.Errorf("TLVs were not skipped.")
+	}
+}
+
+func TestReadV2Local(t *testing.T) {
+	h, err := Read(bufio.NewReader(bytes.NewReader(v2Header(0, 0x00, nil))))
+	if err != nil || h.Source != nil {
+		t.Fatalf("Expected LOCAL header without addresses, got %+v %v.", h, err)
+	}
+}
+
+func TestReadV2Unix(t *testing.T) {
+	body := make([]byte, 216)
+	copy(body, "/var/run/proxy.sock")
+	r := bufio.NewReader(bytes.NewReader(append(v2Header(1, 0x31, body), 'X')))
+	h, err := Read(r)
+	if err != nil || h.Version != 2 || h.Source != nil {
+		t.Fatalf("Expected AF_UNIX header without addresses, got %+v %v.", h, err)
+	}
+	if c, _ := r.ReadByte(); c != 'X' {
+		t.Errorf("Address block was not skipped.")
+	}
+}
+
+func TestReadNoHeader(t *testing.T) {
+	if _, err := Read(bufio.NewReader(bytes.NewReader([]byte("GET / HTTP/1.1\r\n")))); !errors.Is(err, ErrNoHeader) {
+		t.Errorf("Expected ErrNoHeader, got %v.", err)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		_, _ = client.Write([]byte("PROXY TCP6 2001:db8::1 2001:db8::2 1000 2000\r\nabc"))
+	}()
+
+	c, err := Wrap(server, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.RemoteAddr().String() != "[2001:db8::1]:1000" {
+		t.Errorf("Unexpected remote address [%s].", c.RemoteAddr())
+	}
+	buf := make([]byte, 3)
+	if _, err = io.ReadFull(c, buf); err != nil || string(buf) != "abc" {
+		t.Errorf("Expected payload after header, got [%s] %v.", buf, err)
+	}
+}
+
+func TestTrusted(t *testing.T) {
+	_, n, _ := net.ParseCIDR("10.0.0.0/8")
+	if !Trusted(&net.TCPAddr{IP: net.ParseIP("10.2.3.4")}, []*net.IPNet{n}) {
+		t.Errorf("Expected address within CIDR to be trusted.")
+	}
+	if Trusted(&net.TCPAddr{IP: net.ParseIP("11.2.3.4")}, []*net.IPNet{n}) {
+		t.Errorf("Expected address outside CIDR to be untrusted.")
+	}
+}
@@ -0,0 +1,191 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"net"
+	"sync"
+	"time"
+)
+
+type RateLimitPolicy byte
+
+const (
+	// PolicyDrop discards packets which exceed the limit.
+	PolicyDrop RateLimitPolicy = iota
+	// PolicyDelay stops reading from the session until a token is available.
+	PolicyDelay
+	// PolicyDisconnect closes the session.
+	PolicyDisconnect
+)
+
+// RateLimit is a token bucket refilled at Rate tokens per second holding up to Burst tokens. Each packet consumes one token.
+type RateLimit struct {
+	Rate   float64
+	Burst  int
+	Policy RateLimitPolicy
+}
+
+var ErrInvalidRateLimit = errors.New("rate limit needs a positive rate and a burst of at least one")
+
+func (l RateLimit) validate() error {
+	if l.Rate <= 0 || l.Burst < 1 {
+		return ErrInvalidRateLimit
+	}
+	return nil
+}
+
+// validateRateLimits rejects limits which would refuse every packet, or with PolicyDelay stall the read loop forever.
+func (c *config) validateRateLimits() error {
+	if c.packetLimit != nil {
+		if err := c.packetLimit.validate(); err != nil {
+			return err
+		}
+	}
+	for op, limit := range c.opLimits {
+		if err := limit.validate(); err != nil {
+			return fmt.Errorf("op 0x%04X: %w", op, err)
+		}
+	}
+	return nil
+}
+
+type RateLimitEventKind byte
+
+const (
+	EventPacketLimited RateLimitEventKind = iota
+	EventConnectionLimited
+)
+
+// RateLimitEvent describes an enforcement. SessionId is nil and Op is zero for connection limits. Global is set when the session wide limit, rather than an op limit, was exceeded.
+type RateLimitEvent struct {
+	Kind       RateLimitEventKind
+	SessionId  uuid.UUID
+	RemoteAddr net.Addr
+	Op         uint16
+	Global     bool
+	Policy     RateLimitPolicy
+}
+
+type RateLimitHook func(event RateLimitEvent)
+
+func defaultRateLimitHook(_ RateLimitEvent) {
+}
+
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: now}
+}
+
+// ready refills the bucket and reports whether a token is available, otherwise how long until one will be. It does not consume the token.
+func (b *tokenBucket) ready(now time.Time) (bool, time.Duration) {
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	if b.tokens > float64(b.limit.Burst) {
+		b.tokens = float64(b.limit.Burst)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		return true, 0
+	}
+	if b.limit.Rate <= 0 {
+		return false, time.Duration(1<<63 - 1)
+	}
+	return false, time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// take consumes a token if one is available, otherwise it reports how long until one will be.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	ok, wait := b.ready(now)
+	if ok {
+		b.tokens--
+	}
+	return ok, wait
+}
+
+// sessionLimiter holds the buckets of a single session. It is only used from the read loop, so needs no locking.
+type sessionLimiter struct {
+	global *tokenBucket
+	ops    map[uint16]*tokenBucket
+	limits map[uint16]RateLimit
+
+	disconnect bool
+}
+
+func newSessionLimiter(global *RateLimit, limits map[uint16]RateLimit) *sessionLimiter {
+	sl := &sessionLimiter{ops: make(map[uint16]*tokenBucket), limits: limits}
+	if global != nil {
+		sl.global = newTokenBucket(*global, time.Now())
+	}
+	return sl
+}
+
+// allow checks the op bucket before the global bucket. The op token is only consumed once the global bucket admits the packet, so a refused packet costs nothing. The returned bucket is the one which refused the packet.
+func (sl *sessionLimiter) allow(op uint16, now time.Time) (bool, time.Duration, *tokenBucket) {
+	var ob *tokenBucket
+	if limit, ok := sl.limits[op]; ok {
+		ob, ok = sl.ops[op]
+		if !ok {
+			ob = newTokenBucket(limit, now)
+			sl.ops[op] = ob
+		}
+		if ok, wait := ob.ready(now); !ok {
+			return false, wait, ob
+		}
+	}
+	if sl.global != nil {
+		if ok, wait := sl.global.take(now); !ok {
+			return false, wait, sl.global
+		}
+	}
+	if ob != nil {
+		ob.take(now)
+	}
+	return true, 0, nil
+}
+
+// ipCounter tracks concurrent connections per client IP.
+type ipCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newIpCounter() *ipCounter {
+	return &ipCounter{counts: make(map[string]int)}
+}
+
+func (c *ipCounter) acquire(ip string, max int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if max > 0 && c.counts[ip] >= max {
+		return false
+	}
+	c.counts[ip]++
+	return true
+}
+
+func (c *ipCounter) release(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[ip]--
+	if c.counts[ip] <= 0 {
+		delete(c.counts, ip)
+	}
+}
+
+func hostOf(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,214 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"github.com/Chronicle20/atlas-socket/request"
+	"github.com/google/uuid"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newTokenBucket(RateLimit{Rate: 2, Burst: 2}, now)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.take(now); !ok {
+			t.Fatalf("Expected burst token %d.", i)
+		}
+	}
+	ok, wait := b.take(now)
+	if ok {
+		t.Fatalf("Expected bucket to be empty.")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("Expected 500ms wait, got %s.", wait)
+	}
+	if ok, _ = b.take(now.Add(500 * time.Millisecond)); !ok {
+		t.Errorf("Expected token after refill.")
+	}
+	if ok, _ = b.take(now.Add(time.Hour)); !ok {
+		t.Errorf("Expected token after long idle.")
+	}
+	if b.tokens > 2 {
+		t.Errorf("Bucket exceeded burst, %f tokens.", b.tokens)
+	}
+}
+
+func TestSessionLimiterOpBeforeGlobal(t *testing.T) {
+	sl := newSessionLimiter(&RateLimit{Rate: 0, Burst: 2}, map[uint16]RateLimit{0x10: {Rate: 0, Burst: 1, Policy: PolicyDisconnect}})
+	now := time.Now()
+
+	if ok, _, _ := sl.allow(0x10, now); !ok {
+		t.Fatalf("Expected first op packet to pass.")
+	}
+	ok, _, b := sl.allow(0x10, now)
+	if ok || b == sl.global || b.limit.Policy != PolicyDisconnect {
+		t.Fatalf("Expected op bucket to refuse.")
+	}
+	if ok, _, _ = sl.allow(0x11, now); !ok {
+		t.Fatalf("Expected unrelated op to pass.")
+	}
+	if ok, _, b = sl.allow(0x11, now); ok || b != sl.global {
+		t.Fatalf("Expected global bucket to refuse.")
+	}
+}
+
+func TestSessionLimiterRefusalCostsNothing(t *testing.T) {
+	sl := newSessionLimiter(&RateLimit{Rate: 0, Burst: 1}, map[uint16]RateLimit{0x10: {Rate: 0, Burst: 1}})
+	now := time.Now()
+
+	if ok, _, _ := sl.allow(0x11, now); !ok {
+		t.Fatalf("Expected first packet to pass.")
+	}
+	if ok, _, b := sl.allow(0x10, now); ok || b != sl.global {
+		t.Fatalf("Expected global bucket to refuse.")
+	}
+	if sl.ops[0x10].tokens != 1 {
+		t.Errorf("Op bucket was charged for a refused packet, %f tokens left.", sl.ops[0x10].tokens)
+	}
+}
+
+// serveLimited runs a session over a pipe which counts handled packets per op and reports enforcement events and the disconnect reason.
+func serveLimited(t *testing.T, configurators ...Configurator) (net.Conn, chan uint16, chan RateLimitEvent, chan DisconnectReason) {
+	handled := make(chan uint16, 16)
+	events := make(chan RateLimitEvent, 16)
+	reasons := make(chan DisconnectReason, 1)
+	count := func(_ uuid.UUID, r request.Reader) {
+		handled <- uint16(r.GetBuffer()[0])
+	}
+	s := New(append([]Configurator{SetLogger(testLogger()), SetReadWriter(ShortReadWriter{}),
+		SetHandlers(func() map[uint16]request.Handler {
+			return map[uint16]request.Handler{0x0001: count, 0x0002: count}
+		}),
+		SetRateLimitHook(func(event RateLimitEvent) {
+			events <- event
+		}),
+		SetReasonDestroyer(func(_ uuid.UUID, reason DisconnectReason) {
+			reasons <- reason
+		})}, configurators...)...)
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = s.Shutdown(context.Background())
+	})
+	go func() {
+		_ = s.ServeConn(server)
+	}()
+	return client, handled, events, reasons
+}
+
+func writeOps(t *testing.T, conn net.Conn, ops ...byte) {
+	for _, op := range ops {
+		if _, err := conn.Write(frame([]byte{op, 0x00})); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func expectHandled(t *testing.T, handled chan uint16, ops ...uint16) {
+	t.Helper()
+	counts := make(map[uint16]int)
+	for range ops {
+		select {
+		case op := <-handled:
+			counts[op]++
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %d handled packets, got %v.", len(ops), counts)
+		}
+	}
+	for _, op := range ops {
+		counts[op]--
+	}
+	for op, c := range counts {
+		if c != 0 {
+			t.Errorf("Op 0x%04X handled %d times more than expected.", op, c)
+		}
+	}
+}
+
+func TestRateLimitDropThroughSession(t *testing.T) {
+	client, handled, events, _ := serveLimited(t, SetOpRateLimit(0x0001, RateLimit{Rate: 0.01, Burst: 1, Policy: PolicyDrop}))
+
+	// The unlimited op written last proves the session survived the drops and the read loop moved past them.
+	writeOps(t, client, 0x01, 0x01, 0x01, 0x02)
+	expectHandled(t, handled, 0x0001, 0x0002)
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 enforcement events, got %d.", len(events))
+	}
+	if e := <-events; e.Op != 0x0001 || e.Policy != PolicyDrop || e.Global {
+		t.Errorf("Unexpected event %+v.", e)
+	}
+}
+
+func TestRateLimitDelayThroughSession(t *testing.T) {
+	client, handled, events, _ := serveLimited(t, SetPacketRateLimit(RateLimit{Rate: 20, Burst: 1, Policy: PolicyDelay}))
+
+	start := time.Now()
+	writeOps(t, client, 0x01, 0x02, 0x01)
+	expectHandled(t, handled, 0x0001, 0x0002, 0x0001)
+	// Two packets waited for a token at 20 per second.
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("Packets were not delayed, all handled in %s.", elapsed)
+	}
+	if e := <-events; !e.Global || e.Policy != PolicyDelay {
+		t.Errorf("Unexpected event %+v.", e)
+	}
+}
+
+func TestRateLimitDisconnectThroughSession(t *testing.T) {
+	client, handled, events, reasons := serveLimited(t, SetOpRateLimit(0x0002, RateLimit{Rate: 0.01, Burst: 1, Policy: PolicyDisconnect}))
+
+	writeOps(t, client, 0x02)
+	expectHandled(t, handled, 0x0002)
+	// The write may fail once the server closes its end.
+	go func() {
+		_, _ = client.Write(frame([]byte{0x02, 0x00}))
+	}()
+	expectReason(t, reasons, DisconnectRateLimited)
+	if e := <-events; e.Op != 0x0002 || e.Policy != PolicyDisconnect {
+		t.Errorf("Unexpected event %+v.", e)
+	}
+}
+
+func TestInvalidRateLimitsAreRejected(t *testing.T) {
+	for _, c := range []Configurator{
+		SetPacketRateLimit(RateLimit{Rate: 10, Burst: 0, Policy: PolicyDelay}),
+		SetPacketRateLimit(RateLimit{Rate: 0, Burst: 5}),
+		SetOpRateLimit(0x0001, RateLimit{Rate: 10, Burst: -1, Policy: PolicyDrop}),
+	} {
+		s := New(SetLogger(testLogger()), SetIpAddress("127.0.0.1"), SetPort(0), c)
+		if err := s.Start(context.Background()); !errors.Is(err, ErrInvalidRateLimit) {
+			t.Errorf("Expected Start to fail with ErrInvalidRateLimit, got %v.", err)
+			_ = s.Shutdown(context.Background())
+		}
+
+		client, server := net.Pipe()
+		if err := s.ServeConn(server); !errors.Is(err, ErrInvalidRateLimit) {
+			t.Errorf("Expected ServeConn to fail with ErrInvalidRateLimit, got %v.", err)
+		}
+		_ = client.Close()
+	}
+}
+
+func TestIpCounter(t *testing.T) {
+	c := newIpCounter()
+	if !c.acquire("10.0.0.1", 2) || !c.acquire("10.0.0.1", 2) {
+		t.Fatalf("Expected two connections to be admitted.")
+	}
+	if c.acquire("10.0.0.1", 2) {
+		t.Fatalf("Expected third connection to be refused.")
+	}
+	if !c.acquire("10.0.0.2", 2) {
+		t.Fatalf("Expected other address to be admitted.")
+	}
+	c.release("10.0.0.1")
+	if !c.acquire("10.0.0.1", 2) {
+		t.Fatalf("Expected connection after release.")
+	}
+	if !c.acquire("10.0.0.3", 0) {
+		t.Fatalf("Expected zero to mean unlimited.")
+	}
+}
@@ -0,0 +1,44 @@
+package socket
+
+import (
+	"context"
+	"github.com/Chronicle20/atlas-socket/capture"
+	"github.com/Chronicle20/atlas-socket/request"
+	"github.com/google/uuid"
+)
+
+// Replay feeds the inbound records of a capture through the handlers of a configuration, one at a time and in capture order, so a session can be reproduced deterministically. Handlers receive a context carrying the captured session metadata. Outbound records are skipped. Replay stops early when ctx is cancelled.
+//
+//goland:noinspection GoUnusedExportedFunction
+func Replay(ctx context.Context, records []capture.Record, configurators ...Configurator) error {
+	c := newConfig()
+	for _, configurator := range configurators {
+		configurator(c)
+	}
+
+	sessions := make(map[uuid.UUID]context.Context)
+	for _, rec := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if rec.Direction != capture.Inbound {
+			continue
+		}
+
+		sctx, ok := sessions[rec.SessionId]
+		if !ok {
+			sctx = request.WithMetadata(ctx, request.Metadata{SessionId: rec.SessionId, ConnectedAt: rec.Time})
+			sessions[rec.SessionId] = sctx
+		}
+
+		p := request.Request(append([]byte(nil), rec.Data...))
+		reader := request.NewRequestReader(&p, rec.Time.Unix())
+		op := c.rw.Read(&reader)
+		if h, ok := c.handlers[op]; ok {
+			h(sctx, rec.SessionId, reader)
+		} else {
+			c.l.Infof("Replayed a unhandled message with op 0x%02X.", op&0xFF)
+		}
+	}
+	return nil
+}
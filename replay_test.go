@@ -0,0 +1,123 @@
+package socket
+
+import (
+	"bytes"
+	"context"
+	"github.com/Chronicle20/atlas-socket/capture"
+	"github.com/Chronicle20/atlas-socket/request"
+	"github.com/google/uuid"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCaptureAndReplay(t *testing.T) {
+	buf := &bytes.Buffer{}
+	recorder := capture.NewJSONLWriter(buf)
+
+	live := make(chan uint32, 3)
+	s := New(SetLogger(testLogger()), SetReadWriter(ShortReadWriter{}), SetCapture(recorder),
+		SetHandlers(func() map[uint16]request.Handler {
+			return map[uint16]request.Handler{0x0010: func(_ uuid.UUID, r request.Reader) {
+				live <- r.ReadUint32()
+			}}
+		}))
+
+	client, server := net.Pipe()
+	go func() {
+		_ = s.ServeConn(server)
+	}()
+
+	for i := uint32(1); i <= 3; i++ {
+		body := []byte{0x10, 0x00, byte(i), 0x00, 0x00, 0x00}
+		if _, err := client.Write(append([]byte{0x00, 0x00, byte(len(body)), 0x00}, body...)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-live:
+		case <-time.After(time.Second):
+			t.Fatalf("Handler was not invoked.")
+		}
+	}
+	_ = client.Close()
+	_ = s.Shutdown(context.Background())
+
+	records, err := capture.ReadJSONL(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0].Op != 0x0010 || records[0].Direction != capture.Inbound {
+		t.Fatalf("Unexpected capture %+v.", records)
+	}
+
+	var replayed []uint32
+	var session uuid.UUID
+	err = Replay(context.Background(), records, SetLogger(testLogger()), SetReadWriter(ShortReadWriter{}),
+		SetContextHandlers(func() map[uint16]request.ContextHandler {
+			return map[uint16]request.ContextHandler{0x0010: func(ctx context.Context, _ uuid.UUID, r request.Reader) {
+				m, _ := request.MetadataFromContext(ctx)
+				session = m.SessionId
+				replayed = append(replayed, r.ReadUint32())
+			}}
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != 3 || replayed[0] != 1 || replayed[2] != 3 {
+		t.Errorf("Unexpected replay %v.", replayed)
+	}
+	if session != records[0].SessionId {
+		t.Errorf("Expected captured session metadata.")
+	}
+}
+
+func TestCaptureRecordsBothDirections(t *testing.T) {
+	buf := &bytes.Buffer{}
+	recorder := capture.NewJSONLWriter(buf)
+
+	var s *Server
+	s = New(SetLogger(testLogger()), SetReadWriter(ShortReadWriter{}), SetCapture(recorder),
+		SetMessageEncryptor(func(_ uuid.UUID, packet []byte) []byte {
+			out := make([]byte, len(packet))
+			for i, b := range packet {
+				out[i] = b ^ 0xFF
+			}
+			return out
+		}),
+		SetHandlers(func() map[uint16]request.Handler {
+			return map[uint16]request.Handler{0x0010: func(id uuid.UUID, _ request.Reader) {
+				_ = s.Write(id, []byte{0x11, 0x00, 0xCC})
+			}}
+		}))
+
+	client, server := net.Pipe()
+	go func() {
+		_ = s.ServeConn(server)
+	}()
+	if _, err := client.Write([]byte{0x00, 0x00, 0x03, 0x00, 0x10, 0x00, 0xAA}); err != nil {
+		t.Fatal(err)
+	}
+	written := make([]byte, 3)
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(client, written); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.Close()
+	_ = s.Shutdown(context.Background())
+
+	records, err := capture.ReadJSONL(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %+v.", records)
+	}
+	in, out := records[0], records[1]
+	if in.Direction != capture.Inbound || in.Op != 0x0010 || !bytes.Equal(in.Data, []byte{0x10, 0x00, 0xAA}) {
+		t.Errorf("Unexpected inbound record %+v.", in)
+	}
+	if out.Direction != capture.Outbound || out.Op != 0x0011 || !bytes.Equal(out.Data, []byte{0x11, 0x00, 0xCC}) || out.SessionId != in.SessionId {
+		t.Errorf("Expected the plaintext outbound packet, got %+v.", out)
+	}
+}
@@ -0,0 +1,130 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Field is a value read from a traced Reader. Size is the number of bytes consumed. Short is set when too few bytes remained and the zero value was returned instead.
+type Field struct {
+	Offset int
+	Size   int
+	Type   string
+	Value  interface{}
+	Short  bool
+}
+
+// NewDebugReader creates a Reader which records every field read, for use with Trace and Annotate. Copies of the reader share the trace.
+//
+//goland:noinspection GoUnusedExportedFunction
+func NewDebugReader(p *Request, time int64) Reader {
+	r := NewRequestReader(p, time)
+	r.trace = &[]Field{}
+	return r
+}
+
+// Trace returns the fields read so far, or nil when the reader is not a debug reader.
+func (r *Reader) Trace() []Field {
+	if r.trace == nil {
+		return nil
+	}
+	return append([]Field(nil), *r.trace...)
+}
+
+func (r *Reader) record(kind string, start int, value interface{}, short bool) {
+	if r.trace == nil {
+		return
+	}
+	*r.trace = append(*r.trace, Field{Offset: start, Size: r.pos - start, Type: kind, Value: value, Short: short})
+}
+
+// Dump renders the packet as offset annotated hex and ASCII columns, sixteen bytes per row. A caret beneath the hex column marks the current position.
+func (r *Reader) Dump() string {
+	buf := *r.packet
+	sb := &strings.Builder{}
+	_, _ = fmt.Fprintf(sb, "%d bytes, position 0x%04X\n", len(buf), r.pos)
+	for row := 0; row < len(buf); row += 16 {
+		end := min(row+16, len(buf))
+		_, _ = fmt.Fprintf(sb, "%04X  ", row)
+		for i := row; i < row+16; i++ {
+			if i < end {
+				_, _ = fmt.Fprintf(sb, "%02X ", buf[i])
+			} else {
+				sb.WriteString("   ")
+			}
+			if i == row+7 {
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString(" |")
+		for _, b := range buf[row:end] {
+			if b >= 0x20 && b < 0x7F {
+				sb.WriteByte(b)
+			} else {
+				sb.WriteByte('.')
+			}
+		}
+		sb.WriteString("|\n")
+
+		if r.pos >= row && r.pos < row+16 {
+			writeCaret(sb, r.pos-row)
+		}
+	}
+	if r.pos >= len(buf) && len(buf)%16 == 0 {
+		_, _ = fmt.Fprintf(sb, "%04X\n", len(buf))
+		writeCaret(sb, 0)
+	}
+	return sb.String()
+}
+
+func writeCaret(sb *strings.Builder, col int) {
+	indent := 6 + col*3
+	if col > 7 {
+		indent++
+	}
+	sb.WriteString(strings.Repeat(" ", indent))
+	sb.WriteString("^^\n")
+}
+
+// Annotate renders the trace of a debug reader, one field per line with its offset, size, type, value and raw bytes, followed by any bytes no copy of the reader consumed.
+func (r *Reader) Annotate() string {
+	buf := *r.packet
+	sb := &strings.Builder{}
+	if r.trace == nil {
+		sb.WriteString("reader is not tracing\n")
+	}
+	consumed := r.pos
+	for _, f := range r.Trace() {
+		consumed = max(consumed, f.Offset+f.Size)
+		line := fmt.Sprintf("%04X  %-6s %4d  %-24s", f.Offset, f.Type, f.Size, formatValue(f))
+		if f.Size > 0 {
+			line += fmt.Sprintf("  % X", buf[f.Offset:f.Offset+f.Size])
+		}
+		sb.WriteString(strings.TrimRight(line, " "))
+		sb.WriteString("\n")
+	}
+	if rest := len(buf) - consumed; rest > 0 {
+		_, _ = fmt.Fprintf(sb, "%04X  unread %d  % X\n", consumed, rest, buf[consumed:])
+	}
+	return sb.String()
+}
+
+func formatValue(f Field) string {
+	var s string
+	switch v := f.Value.(type) {
+	case string:
+		s = fmt.Sprintf("%q", v)
+	case []byte:
+		s = fmt.Sprintf("[% X]", v)
+	case bool:
+		s = fmt.Sprintf("%t", v)
+	case byte, int8, int16, int32, int64, uint16, uint32, uint64:
+		s = fmt.Sprintf("%d (0x%X)", v, v)
+	default:
+		s = fmt.Sprintf("%v", v)
+	}
+	if f.Short {
+		s += " short"
+	}
+	return s
+}
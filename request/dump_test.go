@@ -0,0 +1,84 @@
+package request
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDumpMarksPosition(t *testing.T) {
+	p := Request([]byte("\x01\x00\x05\x00admin\x07\x00\x00\x00\xAA\xBB\xCC\xDD\xEE\xFF"))
+	r := NewRequestReader(&p, 0)
+	r.Skip(9)
+
+	expected := "19 bytes, position 0x0009\n" +
+		"0000  01 00 05 00 61 64 6D 69  6E 07 00 00 00 AA BB CC  |....admin.......|\n" +
+		"                                  ^^\n" +
+		"0010  DD EE FF                                          |...|\n"
+	if actual := r.Dump(); actual != expected {
+		t.Errorf("Unexpected dump:\n%s\nexpected:\n%s", actual, expected)
+	}
+
+	r.Seek(16)
+	if !strings.HasSuffix(r.Dump(), "|...|\n      ^^\n") {
+		t.Errorf("Position on second row not marked:\n%s", r.Dump())
+	}
+
+	full := Request(make([]byte, 16))
+	r = NewRequestReader(&full, 0)
+	r.Skip(16)
+	if !strings.HasSuffix(r.Dump(), "0010\n      ^^\n") {
+		t.Errorf("End of packet not marked:\n%s", r.Dump())
+	}
+}
+
+func TestDebugReaderTrace(t *testing.T) {
+	p := Request([]byte("\x01\x00\x05\x00admin\x07\x00\x00\x00\x01\xAA\xBB"))
+	r := NewDebugReader(&p, 0)
+	_ = r.ReadUint16()
+
+	// Handlers receive a copy, reads through it still land in the trace.
+	handler := func(c Reader) {
+		_ = c.ReadAsciiString()
+		_ = c.ReadInt32()
+		_ = c.ReadBool()
+		_ = c.ReadInt64()
+	}
+	handler(r)
+
+	trace := r.Trace()
+	expected := []Field{
+		{Offset: 0, Size: 2, Type: "uint16", Value: uint16(1)},
+		{Offset: 2, Size: 7, Type: "ascii", Value: "admin"},
+		{Offset: 9, Size: 4, Type: "int32", Value: int32(7)},
+		{Offset: 13, Size: 1, Type: "bool", Value: true},
+		{Offset: 14, Size: 0, Type: "int64", Value: int64(0), Short: true},
+	}
+	if len(trace) != len(expected) {
+		t.Fatalf("Expected [%d] fields, got [%+v].", len(expected), trace)
+	}
+	for i := range expected {
+		if trace[i] != expected[i] {
+			t.Errorf("Field [%d] is [%+v], expected [%+v].", i, trace[i], expected[i])
+		}
+	}
+
+	a := r.Annotate()
+	for _, s := range []string{
+		"0002  ascii     7  \"admin\"                   05 00 61 64 6D 69 6E",
+		"000E  int64     0  0 (0x0) short",
+		"000E  unread 2  AA BB",
+	} {
+		if !strings.Contains(a, s) {
+			t.Errorf("Annotation missing [%s]:\n%s", s, a)
+		}
+	}
+}
+
+func TestReaderWithoutTrace(t *testing.T) {
+	p := Request([]byte{0x01, 0x02})
+	r := NewRequestReader(&p, 0)
+	_ = r.ReadUint16()
+	if r.Trace() != nil {
+		t.Errorf("Plain reader should not trace.")
+	}
+}
@@ -1,5 +1,41 @@
 package request
 
-import "github.com/google/uuid"
+import (
+	"context"
+	"github.com/google/uuid"
+	"net"
+	"time"
+)
 
 type Handler func(uuid.UUID, Reader)
+
+// ContextHandler receives a per-packet context. It is derived from the session context, so it is cancelled when the session closes or the server shuts down, and it carries the session Metadata and the active tracing span.
+type ContextHandler func(context.Context, uuid.UUID, Reader)
+
+// AdaptHandler converts a Handler into a ContextHandler which ignores the context.
+func AdaptHandler(h Handler) ContextHandler {
+	return func(_ context.Context, sessionId uuid.UUID, r Reader) {
+		h(sessionId, r)
+	}
+}
+
+// Metadata describes the session a packet arrived on.
+type Metadata struct {
+	SessionId   uuid.UUID
+	Listener    string
+	RemoteAddr  net.Addr
+	ConnectedAt time.Time
+}
+
+type metadataKey struct{}
+
+//goland:noinspection GoUnusedExportedFunction
+func WithMetadata(ctx context.Context, m Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, m)
+}
+
+//goland:noinspection GoUnusedExportedFunction
+func MetadataFromContext(ctx context.Context) (Metadata, bool) {
+	m, ok := ctx.Value(metadataKey{}).(Metadata)
+	return m, ok
+}
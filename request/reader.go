@@ -4,6 +4,7 @@ type Reader struct {
 	pos    int
 	packet *Request
 	Time   int64
+	trace  *[]Field
 }
 
 func NewRequestReader(p *Request, time int64) Reader {
@@ -23,111 +24,167 @@ func (r *Reader) GetRestAsBytes() []byte {
 }
 
 func (r *Reader) Skip(amount int) {
-	if len(*r.packet)-(r.pos+amount) >= 0 {
+	start := r.pos
+	if amount >= 0 && len(*r.packet)-(r.pos+amount) >= 0 {
 		r.pos += amount
+		r.record("skip", start, amount, false)
+		return
 	}
+	r.record("skip", start, amount, true)
 }
 
 //goland:noinspection GoStandardMethods
 func (r *Reader) ReadByte() byte {
+	start := r.pos
 	if len(*r.packet)-r.pos > 0 {
-		return r.packet.readByte(&r.pos)
+		v := r.packet.readByte(&r.pos)
+		r.record("byte", start, v, false)
+		return v
 	}
 
+	r.record("byte", start, byte(0), true)
 	return 0
 }
 
 func (r *Reader) ReadInt8() int8 {
+	start := r.pos
 	if len(*r.packet)-r.pos > 0 {
-		return r.packet.readInt8(&r.pos)
+		v := r.packet.readInt8(&r.pos)
+		r.record("int8", start, v, false)
+		return v
 	}
 
+	r.record("int8", start, int8(0), true)
 	return 0
 }
 
 func (r *Reader) ReadBool() bool {
+	start := r.pos
 	if len(*r.packet)-r.pos > 0 {
-		return r.packet.readBool(&r.pos)
+		v := r.packet.readBool(&r.pos)
+		r.record("bool", start, v, false)
+		return v
 	}
 
+	r.record("bool", start, false, true)
 	return false
 }
 
 func (r *Reader) ReadBytes(size int) []byte {
-	if len(*r.packet)-r.pos >= size {
-		return r.packet.readBytes(&r.pos, size)
+	start := r.pos
+	if size >= 0 && len(*r.packet)-r.pos >= size {
+		v := r.packet.readBytes(&r.pos, size)
+		r.record("bytes", start, v, false)
+		return v
 	}
 
+	r.record("bytes", start, []byte{0}, true)
 	return []byte{0}
 }
 
 func (r *Reader) ReadInt16() int16 {
+	start := r.pos
 	if len(*r.packet)-r.pos > 1 {
-		return r.packet.readInt16(&r.pos)
+		v := r.packet.readInt16(&r.pos)
+		r.record("int16", start, v, false)
+		return v
 	}
 
+	r.record("int16", start, int16(0), true)
 	return 0
 }
 
 func (r *Reader) ReadInt32() int32 {
+	start := r.pos
 	if len(*r.packet)-r.pos > 3 {
-		return r.packet.readInt32(&r.pos)
+		v := r.packet.readInt32(&r.pos)
+		r.record("int32", start, v, false)
+		return v
 	}
 
+	r.record("int32", start, int32(0), true)
 	return 0
 }
 
 func (r *Reader) ReadInt64() int64 {
+	start := r.pos
 	if len(*r.packet)-r.pos > 7 {
-		return r.packet.readInt64(&r.pos)
+		v := r.packet.readInt64(&r.pos)
+		r.record("int64", start, v, false)
+		return v
 	}
 
+	r.record("int64", start, int64(0), true)
 	return 0
 }
 
 func (r *Reader) ReadUint16() uint16 {
+	start := r.pos
 	if len(*r.packet)-r.pos > 1 {
-		return r.packet.readUint16(&r.pos)
+		v := r.packet.readUint16(&r.pos)
+		r.record("uint16", start, v, false)
+		return v
 	}
 
+	r.record("uint16", start, uint16(0), true)
 	return 0
 }
 
 func (r *Reader) ReadUint32() uint32 {
+	start := r.pos
 	if len(*r.packet)-r.pos > 3 {
-		return r.packet.readUint32(&r.pos)
+		v := r.packet.readUint32(&r.pos)
+		r.record("uint32", start, v, false)
+		return v
 	}
 
+	r.record("uint32", start, uint32(0), true)
 	return 0
 }
 
 func (r *Reader) ReadUint64() uint64 {
+	start := r.pos
 	if len(*r.packet)-r.pos > 7 {
-		return r.packet.readUint64(&r.pos)
+		v := r.packet.readUint64(&r.pos)
+		r.record("uint64", start, v, false)
+		return v
 	}
 
+	r.record("uint64", start, uint64(0), true)
 	return 0
 }
 
 func (r *Reader) ReadString(size int16) string {
-	if len(*r.packet)-r.pos >= int(size) {
-		return r.packet.readString(&r.pos, int(size))
+	start := r.pos
+	if size >= 0 && len(*r.packet)-r.pos >= int(size) {
+		v := r.packet.readString(&r.pos, int(size))
+		r.record("string", start, v, false)
+		return v
 	}
 
+	r.record("string", start, "", true)
 	return ""
 }
 
 func (r *Reader) ReadAsciiString() string {
+	start := r.pos
+	trace := r.trace
+	r.trace = nil
 	am := r.ReadInt16()
-	return r.ReadString(am)
+	short := am < 0 || len(*r.packet)-r.pos < int(am) || start+2 > len(*r.packet)
+	v := r.ReadString(am)
+	r.trace = trace
+	r.record("ascii", start, v, short)
+	return v
 }
 
 func (r *Reader) Position() int {
 	return r.pos
 }
 
+// Seek moves to offset, clamped to the bounds of the packet.
 func (r *Reader) Seek(offset int) {
-	r.pos = offset
+	r.pos = max(0, min(offset, len(*r.packet)))
 }
 
 func (r *Reader) Available() int {
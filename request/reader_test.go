@@ -0,0 +1,71 @@
+package request
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// FuzzReader interprets ops as a sequence of Reader calls against packet. Each op byte selects a call, the following two bytes supply an argument where one is needed.
+func FuzzReader(f *testing.F) {
+	f.Add([]byte("\x05\x00admin\x07\x00\x00\x00"), []byte{0x0C, 0x05})
+	f.Add([]byte{0xFF, 0xFF, 0x01}, []byte{0x0C, 0x0A, 0xFF, 0xFF, 0x00})
+	f.Add([]byte{0x01, 0x02, 0x03}, []byte{0x0D, 0x00, 0x80, 0x01, 0x0E, 0xFF, 0xFF, 0x00})
+	f.Fuzz(func(t *testing.T, packet []byte, ops []byte) {
+		if len(packet) > 4096 || len(ops) > 256 {
+			return
+		}
+		p := Request(packet)
+		r := NewDebugReader(&p, 0)
+		for i := 0; i < len(ops); i++ {
+			arg := 0
+			if i+2 < len(ops) {
+				arg = int(int16(binary.LittleEndian.Uint16(ops[i+1:])))
+			}
+			switch ops[i] % 16 {
+			case 0:
+				r.ReadByte()
+			case 1:
+				r.ReadInt8()
+			case 2:
+				r.ReadBool()
+			case 3:
+				r.ReadInt16()
+			case 4:
+				r.ReadInt32()
+			case 5:
+				r.ReadInt64()
+			case 6:
+				r.ReadUint16()
+			case 7:
+				r.ReadUint32()
+			case 8:
+				r.ReadUint64()
+			case 9:
+				r.ReadBytes(arg)
+				i += 2
+			case 10:
+				r.ReadString(int16(arg))
+				i += 2
+			case 11:
+				r.GetRestAsBytes()
+			case 12:
+				r.ReadAsciiString()
+			case 13:
+				r.Skip(arg)
+				i += 2
+			case 14:
+				r.Seek(arg)
+				i += 2
+			case 15:
+				_ = r.Dump()
+				_ = r.Annotate()
+			}
+			if r.Position() < 0 || r.Position() > len(packet) {
+				t.Fatalf("Position [%d] outside packet of [%d] bytes.", r.Position(), len(packet))
+			}
+			if r.Available() < 0 {
+				t.Fatalf("Negative bytes available.")
+			}
+		}
+	})
+}
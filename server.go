@@ -4,15 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/Chronicle20/atlas-socket/capture"
 	"github.com/Chronicle20/atlas-socket/crypto"
+	"github.com/Chronicle20/atlas-socket/metrics"
+	"github.com/Chronicle20/atlas-socket/proxyproto"
 	"github.com/Chronicle20/atlas-socket/request"
 	"github.com/Chronicle20/atlas-socket/response"
+	"github.com/Chronicle20/atlas-socket/tracing"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
-	"io"
 	"net"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -57,11 +61,14 @@ func (s ShortReadWriter) Write(op uint16) func(w *response.Writer) {
 
 type HandlerProducer func() map[uint16]request.Handler
 
+type ContextHandlerProducer func() map[uint16]request.ContextHandler
+
 type Creator func(sessionId uuid.UUID, conn net.Conn)
 
 func defaultCreator(_ uuid.UUID, _ net.Conn) {
 }
 
+// MessageDecryptor decrypts an inbound packet body. Returning nil rejects the packet, it is counted as a decrypt failure and dropped.
 type MessageDecryptor func(sessionId uuid.UUID, message []byte) []byte
 
 func defaultMessageDecryptor(_ uuid.UUID, message []byte) []byte {
@@ -70,90 +77,487 @@ func defaultMessageDecryptor(_ uuid.UUID, message []byte) []byte {
 
 type Destroyer func(sessionId uuid.UUID)
 
+// PanicHandler is told of a panic recovered from a handler. The session is then closed with DisconnectHandlerPanic.
+type PanicHandler func(sessionId uuid.UUID, op uint16, recovered interface{})
+
 func defaultDestroyer(_ uuid.UUID) {
 }
 
 type config struct {
+	l         logrus.FieldLogger
+	name      string
+	listener  net.Listener
+	profile   *crypto.CryptoProfile
+	patch     string
+	locale    byte
 	rw        OpReadWriter
 	creator   Creator
 	decryptor MessageDecryptor
-	destroyer Destroyer
+	destroyer ReasonDestroyer
 	ipAddress string
 	port      int
-	handlers  map[uint16]request.Handler
+	handlers  map[uint16]request.ContextHandler
+	timeout   time.Duration
+	panics    PanicHandler
+
+	packetLimit *RateLimit
+	opLimits    map[uint16]RateLimit
+	maxPerIp    int
+	limitHook   RateLimitHook
+	ipConns     *ipCounter
+
+	admission    Admission
+	rejectPacket RejectPacketProducer
+
+	trustedProxies []*net.IPNet
+	proxyTimeout   time.Duration
+
+	idleTimeout time.Duration
+	ping        *PingConfig
+	keepAlive   *net.KeepAliveConfig
+	opNames     map[uint16]string
+	metrics     metrics.Sink
+	tracer      tracing.Tracer
+	sessions    *sessionRegistry
+	stats       *stats
+	capture     capture.Recorder
+	debugReads  bool
+
+	maxPacketSize int
+	outbound      OutboundConfig
+	encryptor     MessageEncryptor
 }
 
-//goland:noinspection GoUnusedExportedFunction
-func Run(l logrus.FieldLogger, ctx context.Context, wg *sync.WaitGroup, configurators ...Configurator) error {
-	wg.Add(1)
-	defer wg.Done()
-
-	c := &config{
+func newConfig() *config {
+	return &config{
+		l:         logrus.StandardLogger(),
 		creator:   defaultCreator,
 		decryptor: defaultMessageDecryptor,
-		destroyer: defaultDestroyer,
+		patch:     "1",
+		locale:    8,
+		destroyer: adaptDestroyer(defaultDestroyer),
 		ipAddress: "0.0.0.0",
 		port:      5000,
-		handlers:  make(map[uint16]request.Handler),
+		handlers:  make(map[uint16]request.ContextHandler),
+		opNames:   make(map[uint16]string),
+		metrics:   metrics.NoopSink{},
+		tracer:    tracing.NoopTracer{},
+		opLimits:  make(map[uint16]RateLimit),
+		limitHook: defaultRateLimitHook,
+		ipConns:   newIpCounter(),
+		admission: defaultAdmission,
+		sessions:  newSessionRegistry(),
+		stats:     &stats{},
+
+		proxyTimeout:  5 * time.Second,
+		maxPacketSize: DefaultMaxPacketSize,
+		outbound:      DefaultOutboundConfig,
+		encryptor:     defaultMessageEncryptor,
 	}
+}
+
+var (
+	ErrServerStarted = errors.New("server already started")
+	ErrServerClosed  = errors.New("server closed")
+)
+
+// Server accepts connections and tracks the sessions created for them.
+type Server struct {
+	config *config
+
+	mu        sync.Mutex
+	listeners []*listener
+	started   bool
+	closed    bool
+	ctx       context.Context
+	cancel    context.CancelFunc
+	accepted  chan error
+	sessions  sync.WaitGroup
+}
+
+// Stats is a point in time snapshot of server activity.
+type Stats struct {
+	ActiveSessions      int64
+	TotalConnections    uint64
+	RejectedConnections uint64
+	BytesRead           uint64
+	PacketsHandled      uint64
+	UnhandledPackets    uint64
+	DecryptFailures     uint64
+	QueuedBytes         int64
+	BytesWritten        uint64
+	SlowConsumers       uint64
+}
 
+type stats struct {
+	active          atomic.Int64
+	connections     atomic.Uint64
+	rejected        atomic.Uint64
+	bytesRead       atomic.Uint64
+	packets         atomic.Uint64
+	unhandled       atomic.Uint64
+	decryptFailures atomic.Uint64
+	queued          atomic.Int64
+	bytesWritten    atomic.Uint64
+	slowConsumers   atomic.Uint64
+}
+
+//goland:noinspection GoUnusedExportedFunction
+func New(configurators ...Configurator) *Server {
+	c := newConfig()
 	for _, configurator := range configurators {
 		configurator(c)
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{config: c, ctx: ctx, cancel: cancel}
+}
 
-	l.Infof("Starting tcp server on [%s:%d]", c.ipAddress, c.port)
-	lis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.ipAddress, c.port))
-	if err != nil {
-		l.WithError(err).Errorln("Error listening:", err.Error())
-		return err
+// Run starts a server and blocks until ctx is cancelled. Sessions are tracked by wg.
+//
+//goland:noinspection GoUnusedExportedFunction
+func Run(l logrus.FieldLogger, ctx context.Context, wg *sync.WaitGroup, configurators ...Configurator) error {
+	return New(append([]Configurator{SetLogger(l)}, configurators...)...).Serve(ctx, wg)
+}
+
+// Start listens on every listener and begins accepting connections in the background. Sessions end when ctx is cancelled or Shutdown is called. If any listener fails to bind, those already bound are closed.
+func (s *Server) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return ErrServerStarted
+	}
+	if s.closed {
+		return ErrServerClosed
 	}
 
-	defer func(lis net.Listener) {
-		err := lis.Close()
-		if err != nil {
-			if errors.Is(err, net.ErrClosed) {
-				return
-			}
-			l.WithError(err).Error("Error closing listener")
+	ls := s.listeners
+	if len(ls) == 0 {
+		ls = []*listener{{config: s.config}}
+		s.listeners = ls
+	}
+	for _, li := range ls {
+		if err := li.config.validate(); err != nil {
+			return err
 		}
-	}(lis)
+	}
 
-	go func() {
-		<-ctx.Done()
-		l.Infof("Closing listener.")
-		err := lis.Close()
+	for i, li := range ls {
+		c := li.config
+		if c.listener != nil {
+			c.l.Infof("Starting server on supplied listener [%s].", c.listener.Addr())
+			li.lis = c.listener
+			continue
+		}
+
+		c.l.Infof("Starting tcp server on [%s:%d]", c.ipAddress, c.port)
+		lis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.ipAddress, c.port))
 		if err != nil {
-			if errors.Is(err, net.ErrClosed) {
-				return
+			c.l.WithError(err).Errorln("Error listening:", err.Error())
+			for _, opened := range ls[:i] {
+				_ = opened.lis.Close()
+				opened.lis = nil
 			}
-			l.WithError(err).Errorf("Error closing listener.")
+			return err
 		}
-	}()
+		li.lis = lis
+	}
+
+	context.AfterFunc(ctx, s.stop)
+	sctx := s.ctx
+	s.started = true
+	s.accepted = make(chan error, len(ls))
+
+	for _, li := range ls {
+		go func(li *listener) {
+			<-sctx.Done()
+			li.config.l.Infof("Closing listener.")
+			err := li.lis.Close()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				li.config.l.WithError(err).Errorf("Error closing listener.")
+			}
+		}(li)
+
+		go func(li *listener) {
+			s.accepted <- s.acceptLoop(sctx, li.config, li.lis)
+		}(li)
+	}
+	return nil
+}
 
+func (s *Server) acceptLoop(ctx context.Context, c *config, lis net.Listener) error {
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
 			select {
 			case <-ctx.Done():
-				l.Infof("Listener stopped accepting new connections.")
+				c.l.Infof("Listener stopped accepting new connections.")
 				return err
 			default:
-				l.WithError(err).Infof("Error accepting connection.")
+				c.l.WithError(err).Infof("Error accepting connection.")
 				continue
 			}
 		}
 
+		if !s.track() {
+			_ = conn.Close()
+			continue
+		}
+		go func() {
+			defer s.sessions.Done()
+			accept(c.l, ctx, &s.sessions)(c, conn)
+		}()
+	}
+}
+
+// Serve starts the server and blocks until ctx is cancelled and every session has ended.
+func (s *Server) Serve(ctx context.Context, wg *sync.WaitGroup) error {
+	wg.Add(1)
+	defer wg.Done()
+
+	if err := s.Start(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	accepting, accepted := len(s.listeners), s.accepted
+	s.mu.Unlock()
+
+	var err error
+	for range accepting {
+		if aerr := <-accepted; err == nil {
+			err = aerr
+		}
+	}
+	s.sessions.Wait()
+	return err
+}
+
+// ServeConn runs the full session pipeline over a connection obtained elsewhere, using the server configuration. It blocks until the session ends. The server need not be started, Shutdown still ends the session.
+func (s *Server) ServeConn(conn net.Conn) error {
+	if err := s.config.validate(); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if !s.track() {
+		_ = conn.Close()
+		return ErrServerClosed
+	}
+	defer s.sessions.Done()
+	s.config.l.Debugf("Serving supplied connection from [%s].", conn.RemoteAddr())
+	accept(s.config.l, s.ctx, &s.sessions)(s.config, conn)
+	return nil
+}
+
+// Shutdown stops accepting connections, closes every session with DisconnectShutdown and waits for them to end or ctx to expire. The server cannot be restarted.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stop()
+
+	done := make(chan struct{})
+	go func() {
+		s.sessions.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// track counts a new session, unless the server is closed. Sessions are counted under the lock which stop sets closed with, so none is counted once waiting for them may have begun.
+func (s *Server) track() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.sessions.Add(1)
+	return true
+}
+
+// stop closes the server to new sessions and ends the running ones.
+func (s *Server) stop() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.cancel()
+}
+
+// Addr is the address of the first listener, or nil before Start.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.listeners) == 0 || s.listeners[0].lis == nil {
+		return nil
+	}
+	return s.listeners[0].lis.Addr()
+}
+
+// Sessions returns a snapshot of the connected sessions.
+func (s *Server) Sessions() []*Session {
+	return s.config.sessions.all()
+}
+
+func (s *Server) Stats() Stats {
+	st := s.config.stats
+	return Stats{
+		ActiveSessions:      st.active.Load(),
+		TotalConnections:    st.connections.Load(),
+		RejectedConnections: st.rejected.Load(),
+		BytesRead:           st.bytesRead.Load(),
+		PacketsHandled:      st.packets.Load(),
+		UnhandledPackets:    st.unhandled.Load(),
+		DecryptFailures:     st.decryptFailures.Load(),
+		QueuedBytes:         st.queued.Load(),
+		BytesWritten:        st.bytesWritten.Load(),
+		SlowConsumers:       st.slowConsumers.Load(),
+	}
+}
+
+// Session returns the connected session with the id.
+func (s *Server) Session(sessionId uuid.UUID) (*Session, bool) {
+	return s.config.sessions.get(sessionId)
+}
+
+// Close ends a session, see Session.Close.
+func (s *Server) Close(sessionId uuid.UUID, reason DisconnectReason) error {
+	ses, ok := s.config.sessions.get(sessionId)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	ses.Close(reason)
+	return nil
+}
+
+// CloseWithPacket ends a session after writing a final packet, see Session.CloseWithPacket.
+func (s *Server) CloseWithPacket(sessionId uuid.UUID, reason DisconnectReason, packet []byte) error {
+	ses, ok := s.config.sessions.get(sessionId)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	return ses.CloseWithPacket(reason, packet)
+}
+
+// WriteAndClose ends a session after writing a final plaintext packet, see Session.WriteAndClose.
+func (s *Server) WriteAndClose(sessionId uuid.UUID, reason DisconnectReason, packet []byte) error {
+	ses, ok := s.config.sessions.get(sessionId)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	return ses.WriteAndClose(reason, packet)
+}
+
+// Write queues a packet for a session, see Session.Write.
+func (s *Server) Write(sessionId uuid.UUID, packet []byte) error {
+	ses, ok := s.config.sessions.get(sessionId)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	return ses.Write(packet)
+}
+
+// accept runs the pre-session pipeline, PROXY protocol, admission and connection limits, before starting the session.
+func accept(l logrus.FieldLogger, ctx context.Context, wg *sync.WaitGroup) func(c *config, conn net.Conn) {
+	return func(c *config, conn net.Conn) {
+		applyKeepAlive(l, conn, c.keepAlive)
+
+		if len(c.trustedProxies) > 0 && proxyproto.Trusted(conn.RemoteAddr(), c.trustedProxies) {
+			pc, err := proxyproto.Wrap(conn, c.proxyTimeout)
+			if err != nil {
+				l.WithError(err).Warnf("Unable to read PROXY protocol header from [%s].", conn.RemoteAddr())
+				_ = conn.Close()
+				return
+			}
+			l.Debugf("Proxy [%s] relayed client [%s].", pc.ProxyAddr(), pc.RemoteAddr())
+			conn = pc
+		}
+
+		if !admit(l, c, conn) {
+			return
+		}
+
+		ip := hostOf(conn.RemoteAddr())
+		if !c.ipConns.acquire(ip, c.maxPerIp) {
+			l.Warnf("Client [%s] exceeded the connection limit.", conn.RemoteAddr())
+			c.limitHook(RateLimitEvent{Kind: EventConnectionLimited, RemoteAddr: conn.RemoteAddr(), Policy: PolicyDisconnect})
+			_ = conn.Close()
+			return
+		}
+		defer c.ipConns.release(ip)
+
 		l.Infof("Client [%s] connected.", conn.RemoteAddr())
+		c.metrics.Add(MetricConnectionsTotal, 1)
+		c.stats.connections.Add(1)
 
-		go run(l, ctx, wg)(c, conn, uuid.New(), 4)
+		run(l, ctx, wg)(c, conn, uuid.New(), 4)
 	}
 }
 
+// admit evaluates the admission hook, closing the connection when it is rejected.
+func admit(l logrus.FieldLogger, c *config, conn net.Conn) bool {
+	ip := ipOf(conn.RemoteAddr())
+	decision := c.admission(ip)
+	if decision == AdmissionAccept {
+		return true
+	}
+
+	l.Infof("Rejected client [%s].", conn.RemoteAddr())
+	c.metrics.Add(MetricConnectionsRejectedTotal, 1)
+	c.stats.rejected.Add(1)
+	if decision == AdmissionRejectWithPacket && c.rejectPacket != nil {
+		_ = conn.SetWriteDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write(c.rejectPacket(ip)); err != nil {
+			l.WithError(err).Debugf("Unable to write reject packet to [%s].", conn.RemoteAddr())
+		}
+	}
+	_ = conn.Close()
+	return false
+}
+
 func run(l logrus.FieldLogger, ctx context.Context, wg *sync.WaitGroup) func(config *config, conn net.Conn, sessionId uuid.UUID, headerSize int) {
 	return func(config *config, conn net.Conn, sessionId uuid.UUID, headerSize int) {
 		wg.Add(1)
 		defer wg.Done()
 
+		config.metrics.Set(MetricConnectionsActive, float64(config.stats.active.Add(1)))
+		defer func() {
+			config.metrics.Set(MetricConnectionsActive, float64(config.stats.active.Add(-1)))
+		}()
+
+		connectedAt := time.Now()
+		sctx, cancel := context.WithCancelCause(request.WithMetadata(ctx, request.Metadata{
+			SessionId:   sessionId,
+			Listener:    config.name,
+			RemoteAddr:  conn.RemoteAddr(),
+			ConnectedAt: connectedAt,
+		}))
+		defer cancel(nil)
+
+		decryptor, encryptor := config.decryptor, config.encryptor
+		if config.profile != nil {
+			var err error
+			if decryptor, encryptor, err = profileCrypto(config, conn); err != nil {
+				l.WithError(err).Errorf("Unable to write handshake to [%s].", conn.RemoteAddr())
+				_ = conn.Close()
+				return
+			}
+		}
+
+		// The creator has not run for sessions which fail the handshake, so the destroyer is only registered past it.
+		reason := DisconnectReason{Category: DisconnectClientClosed}
+		defer func() {
+			config.destroyer(sessionId, reason)
+		}()
+
+		out := newOutboundQueue(l.WithField("session", sessionId.String()), config, sessionId, conn, encryptor, cancel)
+		go out.run(sctx)
+
+		config.sessions.add(&Session{id: sessionId, conn: conn, connectedAt: connectedAt, listener: config.name, profile: config.profile, ctx: sctx, cancel: cancel, out: out})
+		defer config.sessions.remove(sessionId)
+
 		defer func(conn net.Conn) {
 			err := conn.Close()
 			if err != nil {
@@ -167,44 +571,105 @@ func run(l logrus.FieldLogger, ctx context.Context, wg *sync.WaitGroup) func(con
 		}(conn)
 
 		go func() {
-			<-ctx.Done()
-			l.Infof("Closing connection from [%s].", conn.RemoteAddr())
+			<-sctx.Done()
+			if ctx.Err() != nil {
+				l.Infof("Closing connection from [%s].", conn.RemoteAddr())
+			}
 			conn.Close()
 		}()
 
 		config.creator(sessionId, conn)
 
 		header := true
-		readSize := headerSize
+		limiter := newSessionLimiter(config.packetLimit, config.opLimits)
+		lastRead := time.Now()
 
 		fl := l.WithField("session", sessionId.String())
 
-		for {
-			buffer := make([]byte, readSize)
+		monitor := &pingMonitor{}
+		if config.ping != nil {
+			ping := *config.ping
+			if ping.Ping == nil {
+				ping.Ping = writePing(fl, config.rw, ping.PingOp, out)
+			}
+			go runPing(fl, sctx, cancel, ping, sessionId, monitor)
+		}
 
-			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-			_, err := conn.Read(buffer)
+		frames := newFrameReader(conn, headerSize)
+		for {
+			_ = conn.SetReadDeadline(time.Now().Add(config.pollInterval()))
+			n, err := frames.fill()
+			if n > 0 {
+				config.metrics.Add(MetricBytesReadTotal, float64(n))
+				config.stats.bytesRead.Add(uint64(n))
+				lastRead = time.Now()
+			}
 			if err != nil {
 				if os.IsTimeout(err) {
+					if config.idleTimeout > 0 && time.Since(lastRead) >= config.idleTimeout {
+						fl.Infof("Closing idle session.")
+						reason = DisconnectReason{Category: DisconnectIdleTimeout}
+						return
+					}
 					continue
 				}
-				if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
-					l.Infof("Connection ended.")
-				} else {
+				reason = disconnectReason(ctx, sctx, err)
+				if reason.Category == DisconnectReadError {
 					l.WithError(err).Errorf("Error reading from connection.")
+				} else {
+					l.Infof("Connection ended.")
 				}
-				config.destroyer(sessionId)
 				return
 			}
 
+			buffer := frames.frame()
 			if header {
-				readSize = crypto.PacketLength(buffer)
+				size, err := bodySize(crypto.PacketLength(buffer), config.maxPacketSize)
+				if err != nil {
+					fl.Warnf("Closing session which announced a packet of [%d] bytes.", crypto.PacketLength(buffer))
+					reason = DisconnectReason{Category: DisconnectProtocolViolation, Err: err}
+					return
+				}
+				frames.reset(size)
 			} else {
-				readSize = headerSize
+				frames.reset(headerSize)
+
+				result := decryptor(sessionId, buffer)
+				if result == nil {
+					config.metrics.Add(MetricDecryptFailuresTotal, 1)
+					config.stats.decryptFailures.Add(1)
+					fl.Warnf("Dropping packet rejected by decryptor.")
+					header = !header
+					continue
+				}
 
-				result := buffer
-				result = config.decryptor(sessionId, buffer)
-				go handle(fl)(config, sessionId, result)
+				p := request.Request(result)
+				reader := request.NewRequestReader(&p, time.Now().Unix())
+				if config.debugReads {
+					reader = request.NewDebugReader(&p, time.Now().Unix())
+				}
+				op := config.rw.Read(&reader)
+				if config.capture != nil {
+					config.capture.Record(capture.Record{Time: time.Now(), Direction: capture.Inbound, SessionId: sessionId, Op: op, Data: append(capture.Bytes(nil), result...)})
+				}
+				if config.ping != nil && op == config.ping.PongOp {
+					monitor.pong()
+				}
+
+				if !admitPacket(fl, sctx, config, limiter, sessionId, conn.RemoteAddr(), op) {
+					if limiter.disconnect {
+						reason = DisconnectReason{Category: DisconnectRateLimited}
+						return
+					}
+					if sctx.Err() != nil {
+						reason = disconnectReason(ctx, sctx, sctx.Err())
+						return
+					}
+					header = !header
+					continue
+				}
+
+				go handle(fl, sctx)(config, sessionId, conn.RemoteAddr(), op, reader)
 			}
 
 			header = !header
@@ -212,14 +677,103 @@ func run(l logrus.FieldLogger, ctx context.Context, wg *sync.WaitGroup) func(con
 	}
 }
 
-func handle(l logrus.FieldLogger) func(config *config, sessionId uuid.UUID, p request.Request) {
-	return func(config *config, sessionId uuid.UUID, p request.Request) {
-		reader := request.NewRequestReader(&p, time.Now().Unix())
-		op := config.rw.Read(&reader)
+// admitPacket applies the session rate limits to a packet. A delayed packet blocks the read loop until admitted or the session ends.
+func admitPacket(l logrus.FieldLogger, ctx context.Context, config *config, limiter *sessionLimiter, sessionId uuid.UUID, remoteAddr net.Addr, op uint16) bool {
+	ok, wait, b := limiter.allow(op, time.Now())
+	if ok {
+		return true
+	}
+
+	config.limitHook(RateLimitEvent{Kind: EventPacketLimited, SessionId: sessionId, RemoteAddr: remoteAddr, Op: op, Global: b == limiter.global, Policy: b.limit.Policy})
+	switch b.limit.Policy {
+	case PolicyDisconnect:
+		l.Warnf("Disconnecting session which exceeded the rate limit for op 0x%04X.", op)
+		limiter.disconnect = true
+		return false
+	case PolicyDelay:
+		for !ok {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return false
+			}
+			ok, wait, _ = limiter.allow(op, time.Now())
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func handle(l logrus.FieldLogger, ctx context.Context) func(config *config, sessionId uuid.UUID, remoteAddr net.Addr, op uint16, reader request.Reader) {
+	return func(config *config, sessionId uuid.UUID, remoteAddr net.Addr, op uint16, reader request.Reader) {
+		config.metrics.Add(MetricPacketsTotal, 1, opLabel(op))
+		config.stats.packets.Add(1)
+
+		pctx := ctx
+		if config.timeout > 0 {
+			var cancel context.CancelFunc
+			pctx, cancel = context.WithTimeout(ctx, config.timeout)
+			defer cancel()
+		}
+
+		var span tracing.Span = tracing.NoopSpan{}
+		if _, noop := config.tracer.(tracing.NoopTracer); !noop {
+			pctx, span = config.tracer.Start(pctx, "socket.handle",
+				tracing.Attribute{Key: tracing.AttributeSessionId, Value: sessionId.String()},
+				tracing.Attribute{Key: tracing.AttributeOpCode, Value: int64(op)},
+				tracing.Attribute{Key: tracing.AttributeOpName, Value: config.opName(op)},
+				tracing.Attribute{Key: tracing.AttributePayloadSize, Value: int64(len(reader.GetBuffer()))},
+				tracing.Attribute{Key: tracing.AttributeRemoteAddr, Value: remoteAddr.String()})
+		}
+		defer span.End()
+		if config.panics != nil {
+			defer func() {
+				if r := recover(); r != nil {
+					err := fmt.Errorf("handler panic: %v", r)
+					span.RecordError(err)
+					l.WithError(err).Errorf("Closing session after panic handling op 0x%04X.", op)
+					config.panics(sessionId, op, r)
+					if ses, ok := config.sessions.get(sessionId); ok {
+						ses.Close(DisconnectReason{Category: DisconnectHandlerPanic, Err: err})
+					}
+				}
+			}()
+		}
+
+		start := time.Now()
 		if h, ok := config.handlers[op]; ok {
-			h(sessionId, reader)
+			h(pctx, sessionId, reader)
+			if errors.Is(pctx.Err(), context.DeadlineExceeded) {
+				span.RecordError(pctx.Err())
+			}
+			if config.debugReads {
+				l.Debugf("Handled op 0x%04X [%s].\n%s", op, config.opName(op), reader.Annotate())
+			}
 		} else {
+			config.metrics.Add(MetricUnhandledTotal, 1, opLabel(op))
+			config.stats.unhandled.Add(1)
 			l.Infof("Read a unhandled message with op 0x%02X.", op&0xFF)
+			if config.debugReads {
+				l.Debugf("Unhandled op 0x%04X.\n%s", op, reader.Dump())
+			}
+			return
 		}
+		config.metrics.Observe(MetricHandlerDuration, time.Since(start).Seconds(), opLabel(op))
+	}
+}
+
+// pollInterval is how long a single read may block, bounding how late an idle timeout is noticed.
+func (c *config) pollInterval() time.Duration {
+	if c.idleTimeout > 0 && c.idleTimeout < 5*time.Second {
+		return c.idleTimeout
+	}
+	return 5 * time.Second
+}
+
+func (c *config) opName(op uint16) string {
+	if n, ok := c.opNames[op]; ok {
+		return n
 	}
+	return fmt.Sprintf("0x%04X", op)
 }
@@ -0,0 +1,376 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Chronicle20/atlas-socket/request"
+	"github.com/Chronicle20/atlas-socket/tracing"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServerLifecycle(t *testing.T) {
+	reasons := make(chan DisconnectReason, 1)
+	s := New(SetLogger(testLogger()), SetIpAddress("127.0.0.1"), SetPort(0), SetReadWriter(ShortReadWriter{}),
+		SetReasonDestroyer(func(_ uuid.UUID, reason DisconnectReason) {
+			reasons <- reason
+		}))
+
+	if s.Addr() != nil {
+		t.Fatalf("Expected no address before Start.")
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(context.Background()); !errors.Is(err, ErrServerStarted) {
+		t.Fatalf("Expected ErrServerStarted, got %v.", err)
+	}
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for len(s.Sessions()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Session was not registered.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if st := s.Stats(); st.ActiveSessions != 1 || st.TotalConnections != 1 {
+		t.Errorf("Unexpected stats %+v.", st)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if r := <-reasons; r.Category != DisconnectShutdown {
+		t.Errorf("Expected shutdown, got %s.", r)
+	}
+	if err = s.Start(context.Background()); !errors.Is(err, ErrServerStarted) {
+		t.Errorf("Expected ErrServerStarted after shutdown, got %v.", err)
+	}
+	if st := s.Stats(); st.ActiveSessions != 0 {
+		t.Errorf("Expected no active sessions, got %d.", st.ActiveSessions)
+	}
+}
+
+func TestServeConnOverPipe(t *testing.T) {
+	received := make(chan []byte, 1)
+	s := New(SetLogger(testLogger()), SetReadWriter(ShortReadWriter{}),
+		SetHandlers(func() map[uint16]request.Handler {
+			return map[uint16]request.Handler{0x0042: func(_ uuid.UUID, r request.Reader) {
+				received <- r.GetRestAsBytes()
+			}}
+		}))
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ServeConn(server)
+	}()
+
+	body := []byte{0x42, 0x00, 0xAA, 0xBB}
+	if _, err := client.Write(append([]byte{0x00, 0x00, byte(len(body)), 0x00}, body...)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case b := <-received:
+		if string(b) != "\xAA\xBB" {
+			t.Errorf("Unexpected payload [% X].", b)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Handler was not invoked.")
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ServeConn(server); !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Expected ErrServerClosed, got %v.", err)
+	}
+}
+
+func TestSetListener(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(SetLogger(testLogger()), SetReadWriter(ShortReadWriter{}), SetListener(lis))
+	if err = s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if s.Addr().String() != lis.Addr().String() {
+		t.Fatalf("Expected supplied listener address, got [%s].", s.Addr())
+	}
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for len(s.Sessions()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Session was not registered.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	_ = s.Shutdown(context.Background())
+}
+
+func TestOversizedPacketIsProtocolViolation(t *testing.T) {
+	reasons := make(chan DisconnectReason, 1)
+	s := New(SetLogger(testLogger()), SetReadWriter(ShortReadWriter{}), SetMaxPacketSize(16),
+		SetReasonDestroyer(func(_ uuid.UUID, reason DisconnectReason) {
+			reasons <- reason
+		}))
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		_ = s.ServeConn(server)
+	}()
+
+	// The header is delivered a byte at a time.
+	for _, b := range []byte{0x00, 0x00, 0x11, 0x00} {
+		if _, err := client.Write([]byte{b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case r := <-reasons:
+		if r.Category != DisconnectProtocolViolation || !errors.Is(r, ErrPacketTooLarge) {
+			t.Errorf("Unexpected reason [%s].", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Session was not closed.")
+	}
+}
+
+// pendingTracer marks a dispatched packet done when its span ends, which happens after any panic handler has run, so a fuzz run can wait for every handler it started.
+type pendingTracer struct {
+	pending *sync.WaitGroup
+}
+
+func (p pendingTracer) Start(ctx context.Context, _ string, _ ...tracing.Attribute) (context.Context, tracing.Span) {
+	return ctx, pendingSpan{pending: p.pending}
+}
+
+type pendingSpan struct {
+	tracing.NoopSpan
+	pending *sync.WaitGroup
+}
+
+func (s pendingSpan) End() {
+	s.pending.Done()
+}
+
+// FuzzHandle pushes an arbitrary byte stream through the session pipeline, with the identity decryptor, into a set of handlers which parse their bodies. Handler panics are recovered through a panic handler and fail the run, after waiting for every dispatched handler so the failure is attributed to the input which caused it.
+func FuzzHandle(f *testing.F) {
+	packet := func(body ...byte) []byte {
+		return append([]byte{0x00, 0x00, byte(len(body)), byte(len(body) >> 8)}, body...)
+	}
+	f.Add(packet(0x01, 0x00, 0x05, 0x00, 'a', 'd', 'm', 'i', 'n', 0x07, 0x00, 0x00, 0x00))
+	f.Add(append(packet(0x02, 0x00, 0xFF, 0xFF, 0xFF, 0x7F, 0x00, 0x80), packet(0x03, 0x00, 0x10)...))
+	f.Add(append(packet(0x09, 0x00), 0xFF, 0xFF, 0x00))
+	f.Add(packet())
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		l := logrus.New()
+		l.SetOutput(io.Discard)
+		l.SetLevel(logrus.DebugLevel)
+
+		handlers := map[uint16]request.Handler{
+			0x0001: func(_ uuid.UUID, r request.Reader) {
+				_ = r.ReadAsciiString()
+				_ = r.ReadInt32()
+				_ = r.ReadBytes(int(r.ReadInt16()))
+			},
+			0x0002: func(_ uuid.UUID, r request.Reader) {
+				r.Skip(int(r.ReadInt32()))
+				r.Seek(int(r.ReadInt16()))
+				_ = r.ReadInt64()
+				_ = r.GetRestAsBytes()
+			},
+			0x0003: func(_ uuid.UUID, r request.Reader) {
+				for r.Available() > 0 {
+					_ = r.ReadString(int16(r.ReadByte()))
+				}
+			},
+		}
+
+		// Every decrypted packet is dispatched, as no rate limit is set, so the decryptor counts the handlers to wait for.
+		var pending sync.WaitGroup
+		panics := make(chan string, 1)
+		s := New(SetLogger(l), SetReadWriter(ShortReadWriter{}), SetDebugReads(true),
+			SetHandlers(func() map[uint16]request.Handler {
+				return handlers
+			}),
+			SetMessageDecryptor(func(_ uuid.UUID, message []byte) []byte {
+				pending.Add(1)
+				return message
+			}),
+			SetTracer(pendingTracer{pending: &pending}),
+			SetPanicHandler(func(_ uuid.UUID, op uint16, r interface{}) {
+				select {
+				case panics <- fmt.Sprintf("op 0x%04X: %v", op, r):
+				default:
+				}
+			}))
+
+		client, server := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = s.ServeConn(server)
+			pending.Wait()
+		}()
+		go func() {
+			_, _ = client.Write(stream)
+			_ = client.Close()
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Session or handlers did not end after the stream closed.")
+		}
+		_ = client.Close()
+		select {
+		case p := <-panics:
+			t.Fatalf("Handler panicked on %s.", p)
+		default:
+		}
+	})
+}
+
+func TestServeConnReassemblesSplitPackets(t *testing.T) {
+	received := make(chan []byte, 2)
+	s := New(SetLogger(testLogger()), SetReadWriter(ShortReadWriter{}),
+		SetHandlers(func() map[uint16]request.Handler {
+			return map[uint16]request.Handler{0x0042: func(_ uuid.UUID, r request.Reader) {
+				received <- r.GetRestAsBytes()
+			}}
+		}))
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		_ = s.ServeConn(server)
+	}()
+	defer s.Shutdown(context.Background())
+
+	// Two packets, written a byte at a time so every header and body spans several reads.
+	var stream []byte
+	for _, body := range [][]byte{{0x42, 0x00, 0x01, 0x02, 0x03}, {0x42, 0x00, 0x04}} {
+		stream = append(stream, 0x00, 0x00, byte(len(body)), 0x00)
+		stream = append(stream, body...)
+	}
+	for _, b := range stream {
+		if _, err := client.Write([]byte{b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Handlers may run concurrently, so the payloads are compared irrespective of order.
+	got := map[string]bool{}
+	for range 2 {
+		select {
+		case b := <-received:
+			got[string(b)] = true
+		case <-time.After(time.Second):
+			t.Fatalf("Handler was not invoked.")
+		}
+	}
+	if !got["\x01\x02\x03"] || !got["\x04"] {
+		t.Errorf("Unexpected payloads %v.", got)
+	}
+}
+
+func TestSetProxyProtocolRejectsInvalidCIDR(t *testing.T) {
+	c := newConfig()
+	SetProxyProtocol("10.0.0.0/8", "::1/128")(c)
+	if len(c.trustedProxies) != 2 {
+		t.Fatalf("Expected 2 trusted networks, got %d.", len(c.trustedProxies))
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected an invalid CIDR to panic.")
+		}
+	}()
+	SetProxyProtocol("10.0.0.0/8", "10.0.0.300/8")
+}
+
+func TestServeRejectsListenersAfterStart(t *testing.T) {
+	s := New(SetLogger(testLogger()), SetIpAddress("127.0.0.1"))
+	if err := s.AddListener("login", SetPort(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(ctx, &sync.WaitGroup{})
+	}()
+	deadline := time.Now().Add(time.Second)
+	for s.Addr() == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("Server did not start.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := s.AddListener("channel", SetPort(0)); !errors.Is(err, ErrServerStarted) {
+		t.Errorf("Expected ErrServerStarted, got %v.", err)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Serve did not return after cancellation.")
+	}
+}
+
+// Sessions served while Shutdown runs must either be refused or be waited for, never counted after the wait began.
+func TestServeConnRacingShutdown(t *testing.T) {
+	for range 20 {
+		s := New(SetLogger(testLogger()), SetReadWriter(ShortReadWriter{}))
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				client, server := net.Pipe()
+				defer client.Close()
+				if err := s.ServeConn(server); err != nil && !errors.Is(err, ErrServerClosed) {
+					t.Errorf("Unexpected error %v.", err)
+				}
+			}()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if err := s.Shutdown(ctx); err != nil {
+			t.Errorf("Shutdown failed: %v.", err)
+		}
+		cancel()
+		wg.Wait()
+	}
+}
@@ -0,0 +1,141 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"github.com/google/uuid"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session is a connected client. All methods are safe to call from any goroutine.
+type Session struct {
+	id          uuid.UUID
+	conn        net.Conn
+	connectedAt time.Time
+	listener    string
+	profile     *crypto.CryptoProfile
+	ctx         context.Context
+	cancel      context.CancelCauseFunc
+	closeOnce   sync.Once
+	out         *outboundQueue
+}
+
+func (s *Session) Id() uuid.UUID {
+	return s.id
+}
+
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+func (s *Session) ConnectedAt() time.Time {
+	return s.connectedAt
+}
+
+// Listener is the name of the listener which accepted the session, empty for the default listener.
+func (s *Session) Listener() string {
+	return s.listener
+}
+
+// CryptoProfile is the profile configured on the accepting listener.
+func (s *Session) CryptoProfile() (crypto.CryptoProfile, bool) {
+	if s.profile == nil {
+		return crypto.CryptoProfile{}, false
+	}
+	return *s.profile, true
+}
+
+// Context is cancelled when the session ends. Its cause is the DisconnectReason once the session was closed through Close.
+func (s *Session) Context() context.Context {
+	return s.ctx
+}
+
+// Close ends the session. The read loop stops and the destroyer receives the reason. Only the first call has an effect.
+func (s *Session) Close(reason DisconnectReason) {
+	s.closeOnce.Do(func() {
+		s.cancel(reason)
+	})
+}
+
+// Write queues a packet, op included, to be passed through the MessageEncryptor and written in order with every other queued packet. It fails with ErrQueueFull, closing the session, when the client is not keeping up.
+func (s *Session) Write(packet []byte) error {
+	return s.out.enqueue(packet, false, nil)
+}
+
+// QueueDepth is the number of bytes queued and not yet written.
+func (s *Session) QueueDepth() int {
+	return s.out.depth()
+}
+
+// closeFlushTimeout bounds how long closing with a final packet waits for it to be written.
+const closeFlushTimeout = time.Second
+
+// CloseWithPacket writes a final, already encrypted, packet before closing. It is queued behind packets already written through Write, bypassing the MessageEncryptor. The session is closed even if the write fails or does not complete within a second.
+func (s *Session) CloseWithPacket(reason DisconnectReason, packet []byte) error {
+	return s.closeAfter(reason, packet, true)
+}
+
+// WriteAndClose queues a final plaintext packet, passed through the MessageEncryptor like Write, and closes the session once it has been written. The session is closed even if the write fails or does not complete within a second.
+func (s *Session) WriteAndClose(reason DisconnectReason, packet []byte) error {
+	return s.closeAfter(reason, packet, false)
+}
+
+func (s *Session) closeAfter(reason DisconnectReason, packet []byte, raw bool) error {
+	var err error
+	s.closeOnce.Do(func() {
+		flushed := make(chan error, 1)
+		if err = s.out.enqueue(packet, raw, flushed); err == nil {
+			select {
+			case err = <-flushed:
+			case <-time.After(closeFlushTimeout):
+				err = os.ErrDeadlineExceeded
+			}
+		}
+		s.cancel(reason)
+	})
+	return err
+}
+
+type sessionRegistry struct {
+	mu       sync.RWMutex
+	sessions map[uuid.UUID]*Session
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{sessions: make(map[uuid.UUID]*Session)}
+}
+
+func (r *sessionRegistry) add(s *Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[s.id] = s
+}
+
+func (r *sessionRegistry) remove(id uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, id)
+}
+
+func (r *sessionRegistry) get(id uuid.UUID) (*Session, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.sessions[id]
+	return s, ok
+}
+
+func (r *sessionRegistry) all() []*Session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	result := make([]*Session, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		result = append(result, s)
+	}
+	return result
+}
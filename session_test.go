@@ -0,0 +1,99 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startTestSession(t *testing.T, configurators ...Configurator) (*Server, uuid.UUID, net.Conn, chan DisconnectReason) {
+	reasons := make(chan DisconnectReason, 2)
+	configurators = append([]Configurator{
+		SetLogger(testLogger()),
+		SetReadWriter(ShortReadWriter{}),
+		SetReasonDestroyer(func(_ uuid.UUID, reason DisconnectReason) {
+			reasons <- reason
+		}),
+	}, configurators...)
+	s := New(configurators...)
+
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	id := uuid.New()
+	go run(s.config.l, ctx, &sync.WaitGroup{})(s.config, server, id, 4)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := s.Session(id); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Session was not registered.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return s, id, client, reasons
+}
+
+func TestServerCloseKicksSession(t *testing.T) {
+	s, id, _, reasons := startTestSession(t)
+
+	if err := s.Close(id, DisconnectReason{Category: DisconnectKicked}); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Close(id, DisconnectReason{Category: DisconnectProtocolViolation})
+
+	select {
+	case r := <-reasons:
+		if r.Category != DisconnectKicked {
+			t.Errorf("Expected kicked, got %s.", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Destroyer was not invoked.")
+	}
+
+	select {
+	case r := <-reasons:
+		t.Errorf("Destroyer invoked twice, second reason %s.", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, ok := s.Session(id); ok {
+		t.Errorf("Session still registered after close.")
+	}
+	if err := s.Close(id, DisconnectReason{Category: DisconnectKicked}); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound, got %v.", err)
+	}
+}
+
+func TestServerCloseWithPacketFlushes(t *testing.T) {
+	s, id, client, reasons := startTestSession(t)
+
+	received := make(chan []byte, 1)
+	go func() {
+		b, _ := io.ReadAll(client)
+		received <- b
+	}()
+
+	if err := s.CloseWithPacket(id, DisconnectReason{Category: DisconnectKicked}, []byte{0x01, 0x02}); err != nil {
+		t.Fatal(err)
+	}
+
+	if r := <-reasons; r.Category != DisconnectKicked {
+		t.Errorf("Expected kicked, got %s.", r)
+	}
+	if b := <-received; string(b) != "\x01\x02" {
+		t.Errorf("Expected final packet, got [% X].", b)
+	}
+}
@@ -0,0 +1,233 @@
+package sockettest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Chronicle20/atlas-socket"
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"github.com/Chronicle20/atlas-socket/request"
+	"github.com/Chronicle20/atlas-socket/response"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrClosed = errors.New("client closed")
+var ErrTimeout = errors.New("timed out waiting for op")
+
+// Packet is a decrypted packet received from the server. Body excludes the op.
+type Packet struct {
+	Op   uint16
+	Body []byte
+}
+
+// Reader positions a request.Reader at the start of the body.
+func (p Packet) Reader() request.Reader {
+	r := request.Request(p.Body)
+	return request.NewRequestReader(&r, time.Now().Unix())
+}
+
+// Client is a fake MapleStory client. It reads the server handshake, then encrypts outbound and decrypts inbound packets with matching ciphers.
+type Client struct {
+	l         logrus.FieldLogger
+	conn      net.Conn
+	rw        socket.OpReadWriter
+	handshake crypto.Handshake
+	send      *crypto.SendCipher
+	recv      *crypto.RecvCipher
+	packets   chan Packet
+	closing   chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+	err       error
+}
+
+type ClientConfigurator func(c *clientConfig)
+
+type clientConfig struct {
+	l      logrus.FieldLogger
+	rw     socket.OpReadWriter
+	crypto []crypto.Configurator
+	buffer int
+}
+
+//goland:noinspection GoUnusedExportedFunction
+func WithLogger(l logrus.FieldLogger) ClientConfigurator {
+	return func(c *clientConfig) {
+		c.l = l
+	}
+}
+
+// WithOpReadWriter sets how ops are encoded, ShortReadWriter by default.
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithOpReadWriter(rw socket.OpReadWriter) ClientConfigurator {
+	return func(c *clientConfig) {
+		c.rw = rw
+	}
+}
+
+// WithBuffer sets how many received packets are held for Packets and ExpectOp before the client stops reading, 256 by default.
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithBuffer(size int) ClientConfigurator {
+	return func(c *clientConfig) {
+		c.buffer = size
+	}
+}
+
+//goland:noinspection GoUnusedExportedFunction
+func WithCrypto(configurators ...crypto.Configurator) ClientConfigurator {
+	return func(c *clientConfig) {
+		c.crypto = configurators
+	}
+}
+
+// Connect performs the client side of the handshake over conn.
+func Connect(conn net.Conn, configurators ...ClientConfigurator) (*Client, error) {
+	cc := &clientConfig{l: logrus.StandardLogger(), rw: socket.ShortReadWriter{}, buffer: 256}
+	for _, configurator := range configurators {
+		configurator(cc)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	h, err := crypto.ReadHandshake(conn)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		l:         cc.l,
+		conn:      conn,
+		rw:        cc.rw,
+		handshake: h,
+		send:      crypto.NewSendCipher(h.RecvIv, h.Version, cc.crypto...),
+		recv:      crypto.NewRecvCipher(h.SendIv, 0xFFFF-h.Version, cc.crypto...),
+		packets:   make(chan Packet, cc.buffer),
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// Pipe connects a client to the server over net.Pipe. The server side runs through ServeConn.
+//
+//goland:noinspection GoUnusedExportedFunction
+func Pipe(s *socket.Server, configurators ...ClientConfigurator) (*Client, error) {
+	client, server := net.Pipe()
+	go func() {
+		_ = s.ServeConn(server)
+	}()
+	c, err := Connect(client, configurators...)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) Handshake() crypto.Handshake {
+	return c.handshake
+}
+
+// Send writes a packet whose body is produced by the supplied writer functions.
+func (c *Client) Send(op uint16, body ...func(w *response.Writer)) error {
+	w := response.NewWriter(c.l)
+	c.rw.Write(op)(w)
+	for _, b := range body {
+		b(w)
+	}
+	return c.SendRaw(w.Bytes())
+}
+
+// SendRaw encrypts and writes a plaintext packet, op included.
+func (c *Client) SendRaw(packet []byte) error {
+	_, err := c.conn.Write(c.send.Encrypt(true, true)(append(make([]byte, 4), packet...)))
+	return err
+}
+
+// Packets yields decrypted packets. The channel is closed when the connection ends or the client is closed.
+func (c *Client) Packets() <-chan Packet {
+	return c.packets
+}
+
+// ExpectOp waits for the next packet with the op, discarding others.
+func (c *Client) ExpectOp(op uint16, timeout time.Duration) (Packet, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for {
+		select {
+		case p, ok := <-c.packets:
+			if !ok {
+				return Packet{}, c.closedErr()
+			}
+			if p.Op == op {
+				return p, nil
+			}
+		case <-ctx.Done():
+			return Packet{}, fmt.Errorf("op 0x%04X not received within %s: %w", op, timeout, ErrTimeout)
+		}
+	}
+}
+
+// Done is closed once the connection has ended.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close ends the connection. Packets not yet consumed from Packets are discarded.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closing)
+	})
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+func (c *Client) closedErr() error {
+	if c.err != nil {
+		return c.err
+	}
+	return ErrClosed
+}
+
+func (c *Client) readLoop() {
+	defer close(c.done)
+	defer close(c.packets)
+
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(c.conn, header); err != nil {
+			c.finish(err)
+			return
+		}
+		body := make([]byte, crypto.PacketLength(header))
+		if _, err := io.ReadFull(c.conn, body); err != nil {
+			c.finish(err)
+			return
+		}
+		c.recv.DecryptInPlace(true, true)(body)
+
+		p := request.Request(body)
+		r := request.NewRequestReader(&p, time.Now().Unix())
+		op := c.rw.Read(&r)
+		// A full buffer blocks reading, as a real client would, until the packet is consumed or the client is closed.
+		select {
+		case c.packets <- Packet{Op: op, Body: r.GetRestAsBytes()}:
+		case <-c.closing:
+			return
+		}
+	}
+}
+
+func (c *Client) finish(err error) {
+	if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.ErrClosedPipe) {
+		c.err = err
+	}
+}
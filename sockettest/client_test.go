@@ -0,0 +1,107 @@
+package sockettest
+
+import (
+	"context"
+	"github.com/Chronicle20/atlas-socket"
+	"github.com/Chronicle20/atlas-socket/crypto"
+	"github.com/Chronicle20/atlas-socket/request"
+	"github.com/Chronicle20/atlas-socket/response"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestClientEcho(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+
+	var s *socket.Server
+	s = socket.New(
+		socket.SetCryptoProfile(crypto.CryptoProfile{Region: crypto.RegionGMS, Version: 83}),
+		socket.SetLogger(l),
+		socket.SetReadWriter(socket.ShortReadWriter{}),
+		socket.SetHandlers(func() map[uint16]request.Handler {
+			return map[uint16]request.Handler{0x0001: func(sessionId uuid.UUID, r request.Reader) {
+				w := response.NewWriter(l)
+				w.WriteShort(0x0002)
+				w.WriteAsciiString(r.ReadAsciiString())
+				_ = s.Write(sessionId, w.Bytes())
+			}}
+		}))
+
+	for i := 0; i < 3; i++ {
+		c, err := Pipe(s, WithLogger(l))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, msg := range []string{"hello", "world"} {
+			if err = c.Send(0x0001, func(w *response.Writer) {
+				w.WriteAsciiString(msg)
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			p, err := c.ExpectOp(0x0002, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := p.Reader()
+			if actual := r.ReadAsciiString(); actual != msg {
+				t.Errorf("Expected echo [%s], got [%s].", msg, actual)
+			}
+		}
+		_ = c.Close()
+	}
+
+	if _, err := (&Client{packets: make(chan Packet)}).ExpectOp(0x0003, 10*time.Millisecond); err == nil {
+		t.Errorf("Expected timeout error.")
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientCloseWithFullBuffer(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+
+	sent := make(chan struct{})
+	var s *socket.Server
+	s = socket.New(
+		socket.SetCryptoProfile(crypto.CryptoProfile{Region: crypto.RegionGMS, Version: 83}),
+		socket.SetLogger(l),
+		socket.SetReadWriter(socket.ShortReadWriter{}),
+		socket.SetHandlers(func() map[uint16]request.Handler {
+			return map[uint16]request.Handler{0x0001: func(sessionId uuid.UUID, _ request.Reader) {
+				// Two packets fill the buffer, the third is read and blocks delivery.
+				for i := 0; i < 3; i++ {
+					_ = s.Write(sessionId, []byte{0x02, 0x00})
+				}
+				close(sent)
+			}}
+		}))
+	defer s.Shutdown(context.Background())
+
+	c, err := Pipe(s, WithLogger(l), WithBuffer(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Send(0x0001); err != nil {
+		t.Fatal(err)
+	}
+	<-sent
+
+	closed := make(chan struct{})
+	go func() {
+		_ = c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("Close blocked on unconsumed packets.")
+	}
+}
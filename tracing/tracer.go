@@ -0,0 +1,50 @@
+package tracing
+
+import (
+	"context"
+)
+
+// Attribute is a key value pair attached to a span. Values should be strings, integers, floats or booleans so adapters can map them onto their native attribute types.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Span mirrors the subset of the OpenTelemetry span API used by the library.
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// Tracer mirrors the subset of the OpenTelemetry tracer API used by the library. An adapter over an OpenTelemetry trace.Tracer is a few lines and keeps the dependency out of this module.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+type NoopTracer struct {
+}
+
+func (n NoopTracer) Start(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, NoopSpan{}
+}
+
+type NoopSpan struct {
+}
+
+func (n NoopSpan) SetAttributes(_ ...Attribute) {
+}
+
+func (n NoopSpan) RecordError(_ error) {
+}
+
+func (n NoopSpan) End() {
+}
+
+const (
+	AttributeSessionId   = "session.id"
+	AttributeOpCode      = "socket.op.code"
+	AttributeOpName      = "socket.op.name"
+	AttributePayloadSize = "socket.payload.size"
+	AttributeRemoteAddr  = "net.peer.addr"
+)
@@ -0,0 +1,155 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"github.com/Chronicle20/atlas-socket/request"
+	"github.com/Chronicle20/atlas-socket/tracing"
+	"github.com/google/uuid"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingTracer struct {
+	spans chan *recordingSpan
+}
+
+func (r recordingTracer) Start(ctx context.Context, name string, attrs ...tracing.Attribute) (context.Context, tracing.Span) {
+	s := &recordingSpan{name: name, attrs: make(map[string]interface{}), ended: make(chan struct{})}
+	s.SetAttributes(attrs...)
+	r.spans <- s
+	return ctx, s
+}
+
+type recordingSpan struct {
+	name  string
+	mu    sync.Mutex
+	attrs map[string]interface{}
+	errs  []error
+	ended chan struct{}
+}
+
+func (s *recordingSpan) SetAttributes(attrs ...tracing.Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recordingSpan) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errs = append(s.errs, err)
+}
+
+func (s *recordingSpan) End() {
+	close(s.ended)
+}
+
+// serveTraced runs a session over a pipe with the recording tracer and writes one unencrypted packet per body.
+func serveTraced(t *testing.T, tracer recordingTracer, handlers map[uint16]request.ContextHandler, configurators ...Configurator) {
+	s := New(append([]Configurator{SetLogger(testLogger()), SetReadWriter(ShortReadWriter{}), SetTracer(tracer),
+		SetOpNames(map[uint16]string{0x0001: "LOGIN"}),
+		SetContextHandlers(func() map[uint16]request.ContextHandler {
+			return handlers
+		})}, configurators...)...)
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = s.Shutdown(context.Background())
+	})
+	go func() {
+		_ = s.ServeConn(server)
+	}()
+	go func() {
+		_, _ = client.Write([]byte{0x00, 0x00, 0x03, 0x00, 0x01, 0x00, 0xAA})
+	}()
+}
+
+func awaitSpan(t *testing.T, tracer recordingTracer) *recordingSpan {
+	select {
+	case s := <-tracer.spans:
+		select {
+		case <-s.ended:
+			return s
+		case <-time.After(time.Second):
+			t.Fatalf("Span was not ended.")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("No span was started.")
+	}
+	return nil
+}
+
+func TestHandleStartsAttributedSpan(t *testing.T) {
+	tracer := recordingTracer{spans: make(chan *recordingSpan, 1)}
+	var sessionId uuid.UUID
+	serveTraced(t, tracer, map[uint16]request.ContextHandler{0x0001: func(_ context.Context, id uuid.UUID, _ request.Reader) {
+		sessionId = id
+	}})
+
+	s := awaitSpan(t, tracer)
+	if s.name != "socket.handle" {
+		t.Errorf("Unexpected span name [%s].", s.name)
+	}
+	expected := map[string]interface{}{
+		tracing.AttributeSessionId:   sessionId.String(),
+		tracing.AttributeOpCode:      int64(0x0001),
+		tracing.AttributeOpName:      "LOGIN",
+		tracing.AttributePayloadSize: int64(3),
+		tracing.AttributeRemoteAddr:  "pipe",
+	}
+	for k, v := range expected {
+		if s.attrs[k] != v {
+			t.Errorf("Attribute [%s] is [%v], expected [%v].", k, s.attrs[k], v)
+		}
+	}
+}
+
+func TestHandleEndsSpanOnPanic(t *testing.T) {
+	tracer := recordingTracer{spans: make(chan *recordingSpan, 1)}
+	recovered := make(chan interface{}, 1)
+	reasons := make(chan DisconnectReason, 1)
+	serveTraced(t, tracer, map[uint16]request.ContextHandler{0x0001: func(_ context.Context, _ uuid.UUID, _ request.Reader) {
+		panic("boom")
+	}}, SetPanicHandler(func(_ uuid.UUID, op uint16, r interface{}) {
+		recovered <- r
+	}), SetReasonDestroyer(func(_ uuid.UUID, reason DisconnectReason) {
+		reasons <- reason
+	}))
+
+	s := awaitSpan(t, tracer)
+	s.mu.Lock()
+	if len(s.errs) != 1 {
+		t.Errorf("Expected the panic to be recorded, got %v.", s.errs)
+	}
+	s.mu.Unlock()
+	if r := <-recovered; r != "boom" {
+		t.Errorf("Unexpected recovered value [%v].", r)
+	}
+	select {
+	case r := <-reasons:
+		if r.Category != DisconnectHandlerPanic {
+			t.Errorf("Expected handler panic, got %s.", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Session was not closed.")
+	}
+}
+
+func TestHandleEndsSpanOnTimeout(t *testing.T) {
+	tracer := recordingTracer{spans: make(chan *recordingSpan, 1)}
+	serveTraced(t, tracer, map[uint16]request.ContextHandler{0x0001: func(ctx context.Context, _ uuid.UUID, _ request.Reader) {
+		<-ctx.Done()
+	}}, SetHandlerTimeout(20*time.Millisecond))
+
+	s := awaitSpan(t, tracer)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.errs) != 1 || !errors.Is(s.errs[0], context.DeadlineExceeded) {
+		t.Fatalf("Expected the deadline to be recorded, got %v.", s.errs)
+	}
+}